@@ -6,29 +6,46 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/ash2k/stager"
 	"github.com/atlassian/ctrl"
 	"github.com/atlassian/ctrl/flagutil"
+	"github.com/atlassian/ctrl/healthz"
+	"github.com/atlassian/ctrl/loglevel"
 	"github.com/atlassian/ctrl/logz"
 	"github.com/atlassian/ctrl/options"
 	"github.com/atlassian/ctrl/process"
+	"github.com/atlassian/ctrl/tracing"
 	"github.com/prometheus/client_golang/prometheus"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
+	coordination_v1 "k8s.io/api/coordination/v1"
 	core_v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
 	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	core_v1client "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/metadata"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
 	"k8s.io/client-go/tools/record"
-	"k8s.io/client-go/util/workqueue"
+	"k8s.io/client-go/transport"
 )
 
 const (
 	defaultAuxServerAddr = ":9090"
+
+	// tracingShutdownTimeout is the maximum duration of exporting remaining spans on shutdown.
+	tracingShutdownTimeout = 5 * time.Second
 )
 
 type PrometheusRegistry interface {
@@ -41,11 +58,26 @@ type App struct {
 
 	options.GenericNamespacedControllerOptions
 	options.LeaderElectionOptions
+	options.ShardingOptions
+	options.ClusterOptions
 	options.RestClientOptions
 	options.LoggerOptions
+	options.TracingOptions
 
-	MainClient         kubernetes.Interface
+	MainClient     kubernetes.Interface
+	DynamicClient  dynamic.Interface
+	MetadataClient metadata.Interface
+	RESTMapper     meta.RESTMapper
+	// Clusters are additional clusters watched by multi-cluster controllers.
+	Clusters           []ctrl.Cluster
 	PrometheusRegistry PrometheusRegistry
+	// LogLevels is used to change log levels of controllers at runtime if Debug is set. Optional. Logger must
+	// have been constructed by it.
+	LogLevels *loglevel.Registry
+	// TracerProvider is used to trace processing of objects, server requests and requests to the Kubernetes API
+	// that are made with clients of ProcessContext.Cluster (see tracing.Transport).
+	// Optional. Tracing is disabled if nil. It is shut down when Run returns.
+	TracerProvider *sdktrace.TracerProvider
 
 	// Name is the name of the application. It must only contain alphanumeric
 	// characters.
@@ -63,37 +95,26 @@ func (a *App) Run(ctx context.Context) (retErr error) {
 		}
 	}()
 
-	// Controller
-	config := &ctrl.Config{
-		AppName:      a.Name,
-		Namespace:    a.Namespace,
-		ResyncPeriod: a.ResyncPeriod,
-		Registry:     a.PrometheusRegistry,
-		Logger:       a.Logger,
-
-		RestConfig: a.RestConfig,
-		MainClient: a.MainClient,
-	}
-	generic, err := process.NewGeneric(config,
-		workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "multiqueue"),
-		a.Workers, a.Controllers...)
-	if err != nil {
-		return err
-	}
-
-	// Auxiliary server
-	auxSrv := AuxServer{
-		Logger:   a.Logger,
-		Addr:     a.AuxListenOn,
-		Gatherer: a.PrometheusRegistry,
-		IsReady:  generic.IsReady,
-		Debug:    a.Debug,
+	// Tracing
+	var tracerProvider trace.TracerProvider
+	if a.TracerProvider != nil {
+		tracerProvider = a.TracerProvider
+		defer func() {
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), tracingShutdownTimeout)
+			defer cancel()
+			if err := a.TracerProvider.Shutdown(shutdownCtx); err != nil {
+				a.Logger.Error("Failed to flush remaining spans", zap.Error(err))
+			}
+		}()
 	}
 
 	// Events
 	eventsScheme := runtime.NewScheme()
-	// we use ConfigMapLock which emits events for ConfigMap and hence we need (only) core_v1 types for it
-	if err = core_v1.AddToScheme(eventsScheme); err != nil {
+	// resource locks emit events for ConfigMaps and Leases and hence we need (only) core_v1 and coordination_v1 types for them
+	if err := core_v1.AddToScheme(eventsScheme); err != nil {
+		return err
+	}
+	if err := coordination_v1.AddToScheme(eventsScheme); err != nil {
 		return err
 	}
 
@@ -105,6 +126,67 @@ func (a *App) Run(ctx context.Context) (retErr error) {
 	defer recordingWatch.Stop()
 	recorder := eventBroadcaster.NewRecorder(eventsScheme, core_v1.EventSource{Component: a.Name})
 
+	// Health checks
+	healthChecks := healthz.NewRegistry()
+	err := healthChecks.AddCheck("ping", healthz.Liveness, func(context.Context) error {
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Controller
+	config := &ctrl.Config{
+		AppName:           a.Name,
+		Namespace:         a.Namespace,
+		Namespaces:        a.Namespaces,
+		NamespaceSelector: a.NamespaceSelector,
+		InformerSelector:  a.InformerSelector,
+		InformerTransform: a.InformerTransform(),
+		ResyncPeriod:      a.ResyncPeriod,
+		Registry:          a.PrometheusRegistry,
+		Logger:            a.Logger,
+		LogLevels:         a.LogLevels,
+		RetryPolicy:       a.RetryPolicy,
+		ProcessTimeout:    a.ProcessTimeout,
+		RecoverPanics:     a.RecoverPanics,
+
+		EventBroadcaster: eventBroadcaster,
+		EmitDropEvents:   a.EmitDropEvents,
+
+		LivenessStuckThreshold:       a.LivenessStuckThreshold,
+		LivenessInformerEventTimeout: a.LivenessInformerEventTimeout,
+		HealthChecks:                 healthChecks,
+		ObjectMetricsCardinality:     a.ObjectMetricsCardinality,
+		SlowObjects:                  a.SlowObjects,
+		TracerProvider:               tracerProvider,
+
+		RestConfig:     a.RestConfig,
+		MainClient:     a.MainClient,
+		DynamicClient:  a.DynamicClient,
+		MetadataClient: a.MetadataClient,
+		RESTMapper:     a.RESTMapper,
+		Clusters:       a.Clusters,
+	}
+	generic, err := process.NewGeneric(config, a.Workers, a.Controllers...)
+	if err != nil {
+		return err
+	}
+
+	// Auxiliary server
+	auxSrv := AuxServer{
+		Logger:       a.Logger,
+		Addr:         a.AuxListenOn,
+		Gatherer:     a.PrometheusRegistry,
+		IsReady:      generic.IsReady,
+		Health:       generic.Health,
+		HealthChecks: healthChecks,
+		SlowObjects:  generic.SlowObjects,
+		Leadership:   generic.Leadership,
+		LogLevels:    a.LogLevels,
+		Debug:        a.Debug,
+	}
+
 	var auxErr error
 	defer func() {
 		if auxErr != nil && (retErr == context.DeadlineExceeded || retErr == context.Canceled) {
@@ -125,17 +207,63 @@ func (a *App) Run(ctx context.Context) (retErr error) {
 		auxErr = auxSrv.Run(metricsCtx)
 	})
 
+	// Sharding
+	if a.ShardingOptions.Shard {
+		a.Logger.Info("Joining shard group",
+			logz.NamespaceName(a.ShardingOptions.ShardNamespace),
+			zap.String("shard_group", a.ShardingOptions.ShardGroup),
+			zap.String("shard_identity", a.ShardingOptions.ShardIdentity))
+		sharder := process.NewSharder(a.Logger, a.MainClient.CoordinationV1(), process.ShardingConfig{
+			Namespace:     a.ShardingOptions.ShardNamespace,
+			Group:         a.ShardingOptions.ShardGroup,
+			Identity:      a.ShardingOptions.ShardIdentity,
+			LeaseDuration: a.ShardingOptions.ShardLeaseDuration,
+			RenewPeriod:   a.ShardingOptions.ShardRenewPeriod,
+			ByNamespace:   a.ShardingOptions.ShardByNamespace,
+		})
+		return generic.RunWithSharding(ctx, sharder)
+	}
+
 	// Leader election
-	if a.LeaderElectionOptions.LeaderElect {
-		a.Logger.Info("Starting leader election", logz.NamespaceName(a.LeaderElectionOptions.ConfigMapNamespace))
-		ctx, err = options.DoLeaderElection(ctx, a.Logger, a.Name, a.LeaderElectionOptions, a.MainClient.CoreV1(), recorder)
-		if err != nil {
-			return err
+	if !a.LeaderElectionOptions.LeaderElect {
+		return generic.Run(ctx)
+	}
+	elect := func(ctx context.Context) (context.Context, func(), error) {
+		return a.doLeaderElection(ctx, a.LeaderElectionOptions, recorder)
+	}
+	if a.LeaderElectionOptions.WarmStandby || generic.HasSeparateLeaderElection() {
+		electController := func(gvk schema.GroupVersionKind) process.Elector {
+			config := a.LeaderElectionOptions
+			config.ResourceName = controllerLockName(config.ResourceName, gvk)
+			return func(ctx context.Context) (context.Context, func(), error) {
+				return a.doLeaderElection(ctx, config, recorder)
+			}
 		}
+		return generic.RunWithLeaderElection(ctx, elect, electController, a.LeaderElectionOptions.StandbyRunControllers)
 	}
+	ctx, release, err := elect(ctx)
+	if err != nil {
+		return err
+	}
+	// generic.Run() returns once all workers have stopped so the lease is released only after that
+	defer release()
 	return generic.Run(ctx)
 }
 
+// doLeaderElection contends for the lock described by config. See options.DoLeaderElection.
+func (a *App) doLeaderElection(ctx context.Context, config options.LeaderElectionOptions, recorder record.EventRecorder) (context.Context, func(), error) {
+	a.Logger.Info("Starting leader election",
+		logz.NamespaceName(config.ResourceNamespace),
+		zap.String("resource_name", config.ResourceName),
+		zap.String("resource_lock", config.ResourceLock))
+	return options.DoLeaderElection(ctx, a.Logger, a.Name, config, a.MainClient, recorder, a.PrometheusRegistry)
+}
+
+// controllerLockName returns the name of the leader election lock for a controller that has a separate lock.
+func controllerLockName(resourceName string, gvk schema.GroupVersionKind) string {
+	return resourceName + "-" + strings.ToLower(gvk.GroupKind().String())
+}
+
 // CancelOnInterrupt calls f when os.Interrupt or SIGTERM is received.
 // It ignores subsequent interrupts on purpose - program should exit correctly after the first signal.
 func CancelOnInterrupt(ctx context.Context, f context.CancelFunc) {
@@ -159,13 +287,16 @@ func NewFromFlags(name string, controllers []ctrl.Constructor, flagset *flag.Fla
 		cntrlr.AddFlags(flagset)
 	}
 
-	flagset.BoolVar(&a.Debug, "debug", false, "Enables pprof and prefetcher dump endpoints")
+	flagset.BoolVar(&a.Debug, "debug", false, "Enables pprof and prefetcher dump endpoints and changing log levels at runtime")
 	flagset.StringVar(&a.AuxListenOn, "aux-listen-on", defaultAuxServerAddr, "Auxiliary address to listen on. Used for Prometheus metrics server and pprof endpoint. Empty to disable")
 
 	options.BindLeaderElectionFlags(name, &a.LeaderElectionOptions, flagset)
+	options.BindShardingFlags(name, &a.ShardingOptions, flagset)
+	options.BindClusterFlags(&a.ClusterOptions, flagset)
 	options.BindGenericNamespacedControllerFlags(&a.GenericNamespacedControllerOptions, flagset)
 	options.BindRestClientFlags(&a.RestClientOptions, flagset)
 	options.BindLoggerFlags(&a.LoggerOptions, flagset)
+	options.BindTracingFlags(&a.TracingOptions, flagset)
 
 	if err := flagutil.ValidateFlags(flagset, arguments); err != nil {
 		return nil, err
@@ -180,6 +311,21 @@ func NewFromFlags(name string, controllers []ctrl.Constructor, flagset *flag.Fla
 	if errs := a.RestClientOptions.DefaultAndValidate(); len(errs) > 0 {
 		return nil, errors.NewAggregate(errs)
 	}
+	if errs := a.LeaderElectionOptions.DefaultAndValidate(); len(errs) > 0 {
+		return nil, errors.NewAggregate(errs)
+	}
+	if errs := a.ShardingOptions.DefaultAndValidate(name); len(errs) > 0 {
+		return nil, errors.NewAggregate(errs)
+	}
+	if errs := a.ClusterOptions.DefaultAndValidate(); len(errs) > 0 {
+		return nil, errors.NewAggregate(errs)
+	}
+	if errs := a.TracingOptions.DefaultAndValidate(); len(errs) > 0 {
+		return nil, errors.NewAggregate(errs)
+	}
+	if a.Shard && a.LeaderElect {
+		return nil, fmt.Errorf("sharding and leader election cannot be enabled at the same time")
+	}
 
 	var err error
 	a.RestConfig, err = options.LoadRestClientConfig(name, a.RestClientOptions)
@@ -187,13 +333,38 @@ func NewFromFlags(name string, controllers []ctrl.Constructor, flagset *flag.Fla
 		return nil, err
 	}
 
-	a.Logger = options.LoggerFromOptions(a.LoggerOptions)
+	a.Logger, a.LogLevels = options.LoggerWithLevelsFromOptions(a.LoggerOptions)
+
+	// Tracing
+	a.TracerProvider, err = options.NewTracerProvider(name, a.TracingOptions)
+	if err != nil {
+		return nil, err
+	}
+	var wrapTransport transport.WrapperFunc
+	if a.TracerProvider != nil {
+		wrapTransport = tracing.Transport(a.TracerProvider)
+		a.RestConfig.WrapTransport = transport.Wrappers(a.RestConfig.WrapTransport, wrapTransport)
+	}
 
 	// Clients
 	a.MainClient, err = kubernetes.NewForConfig(a.RestConfig)
 	if err != nil {
 		return nil, err
 	}
+	a.DynamicClient, err = dynamic.NewForConfig(a.RestConfig)
+	if err != nil {
+		return nil, err
+	}
+	a.MetadataClient, err = metadata.NewForConfig(a.RestConfig)
+	if err != nil {
+		return nil, err
+	}
+	// Discovery information is cached and refreshed when a GVK cannot be found e.g. if a CRD has been added
+	a.RESTMapper = restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(a.MainClient.Discovery()))
+	a.Clusters, err = options.LoadClusters(name, a.RestClientOptions, a.ClusterOptions, a.MainClient, wrapTransport)
+	if err != nil {
+		return nil, err
+	}
 
 	// Metrics
 	a.PrometheusRegistry = prometheus.NewPedanticRegistry()
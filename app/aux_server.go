@@ -2,17 +2,21 @@ package app
 
 import (
 	"context"
+	"encoding/json"
 	"io"
 	"net/http"
 	"net/http/pprof"
 	"time"
 
+	"github.com/atlassian/ctrl/healthz"
+	"github.com/atlassian/ctrl/loglevel"
 	"github.com/atlassian/ctrl/process"
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 )
 
 const (
@@ -30,7 +34,18 @@ type AuxServer struct {
 	Addr     string // TCP address to listen on, ":http" if empty
 	Gatherer prometheus.Gatherer
 	IsReady  func() bool
-	Debug    bool
+	// Health returns the liveness state of controllers. Optional.
+	Health func() process.HealthReport
+	// HealthChecks are served on /healthz, /livez and /readyz. Optional.
+	HealthChecks *healthz.Registry
+	// SlowObjects returns objects that took longest to process. Optional.
+	SlowObjects func() []process.SlowObject
+	// Leadership returns whether this replica is leading each of the controllers. Optional.
+	Leadership func() map[schema.GroupVersionKind]bool
+	// LogLevels is served on /debug/loglevel. Log levels can be changed at runtime only if Debug is set,
+	// otherwise they are read-only. Optional.
+	LogLevels *loglevel.Registry
+	Debug     bool
 }
 
 func (a *AuxServer) Run(ctx context.Context) error {
@@ -63,6 +78,29 @@ func (a *AuxServer) constructHandler() *chi.Mux {
 		}
 		w.WriteHeader(http.StatusOK)
 	})
+	if a.Health != nil {
+		router.Get("/healthz/live", a.live)
+	}
+	if a.HealthChecks != nil {
+		router.Method(http.MethodGet, "/healthz", a.HealthChecks.HealthzHandler(a.Logger))
+		router.Method(http.MethodGet, "/livez", a.HealthChecks.LivezHandler(a.Logger))
+		router.Method(http.MethodGet, "/livez/*", a.HealthChecks.CheckHandler(a.Logger, "/livez/", healthz.Liveness))
+		router.Method(http.MethodGet, "/readyz", a.HealthChecks.ReadyzHandler(a.Logger))
+		router.Method(http.MethodGet, "/readyz/*", a.HealthChecks.CheckHandler(a.Logger, "/readyz/", healthz.Liveness, healthz.Readiness))
+	}
+	if a.SlowObjects != nil {
+		router.Get("/slowobjects", a.slowObjects)
+	}
+	if a.Leadership != nil {
+		router.Get("/leadership", a.leadership)
+	}
+	if a.LogLevels != nil {
+		if a.Debug {
+			router.Handle("/debug/loglevel", a.LogLevels.Handler(a.Logger))
+		} else {
+			router.Method(http.MethodGet, "/debug/loglevel", a.LogLevels.Handler(a.Logger))
+		}
+	}
 	if a.Debug {
 		// Enable debug endpoints
 		router.HandleFunc("/debug/pprof/", pprof.Index)
@@ -82,6 +120,42 @@ func (a *AuxServer) setServerHeader(next http.Handler) http.Handler {
 	})
 }
 
+// live responds with a JSON body that describes the liveness state of each controller.
+// Responds with 503 if any controller is not live.
+func (a *AuxServer) live(w http.ResponseWriter, _ *http.Request) {
+	report := a.Health()
+	w.Header().Set("Content-Type", "application/json")
+	if report.Live {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		a.Logger.Debug("Failed to write liveness response", zap.Error(err))
+	}
+}
+
+// slowObjects responds with a JSON array of objects that took longest to process, slowest first.
+func (a *AuxServer) slowObjects(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(a.SlowObjects()); err != nil {
+		a.Logger.Debug("Failed to write slow objects response", zap.Error(err))
+	}
+}
+
+// leadership responds with a JSON object that maps controllers' GVKs to whether this replica is leading them.
+func (a *AuxServer) leadership(w http.ResponseWriter, _ *http.Request) {
+	leadership := a.Leadership()
+	response := make(map[string]bool, len(leadership))
+	for gvk, leading := range leadership {
+		response[gvk.String()] = leading
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		a.Logger.Debug("Failed to write leadership response", zap.Error(err))
+	}
+}
+
 func pageNotFound(w http.ResponseWriter, _ *http.Request) {
 	w.WriteHeader(http.StatusNotFound)
 }
@@ -0,0 +1,112 @@
+package ctrl
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/informers/internalinterfaces"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Cluster holds clients for a Kubernetes cluster.
+type Cluster struct {
+	// Name identifies the cluster. Empty for the main cluster.
+	Name          string
+	RestConfig    *rest.Config
+	MainClient    kubernetes.Interface
+	DynamicClient dynamic.Interface
+}
+
+// MainCluster returns the main cluster i.e. the cluster of Config's clients.
+func (c *Config) MainCluster() Cluster {
+	return Cluster{
+		RestConfig:    c.RestConfig,
+		MainClient:    c.MainClient,
+		DynamicClient: c.DynamicClient,
+	}
+}
+
+// RegisterClusterInformer registers an informer for gvk in an additional cluster.
+func (c *Context) RegisterClusterInformer(cluster string, gvk schema.GroupVersionKind, inf cache.SharedIndexInformer) error {
+	if cluster == "" {
+		return c.RegisterInformer(gvk, inf)
+	}
+	if _, ok := c.ClusterInformers[cluster][gvk]; ok {
+		return errors.Errorf("informer with this GVK has been registered already for cluster %q", cluster)
+	}
+	if c.ClusterInformers == nil {
+		c.ClusterInformers = make(map[string]map[schema.GroupVersionKind]cache.SharedIndexInformer)
+	}
+	if c.ClusterInformers[cluster] == nil {
+		c.ClusterInformers[cluster] = make(map[schema.GroupVersionKind]cache.SharedIndexInformer)
+	}
+	c.ClusterInformers[cluster][gvk] = inf
+	return nil
+}
+
+// MultiClusterInformer constructs and registers informers for gvk in the main cluster (see MainInformer)
+// and in all additional clusters from Config.Clusters. Returns informers by cluster name, the main cluster's
+// informer has an empty name. Objects from all clusters are processed by the controller for gvk and
+// ProcessContext.Cluster is the cluster the object came from. Selectors are handled the same way as
+// by MainInformer in all clusters, MultiClusterFilteredInformer must be used to restrict objects.
+func (c *Context) MultiClusterInformer(config *Config, gvk schema.GroupVersionKind, f func(kubernetes.Interface, string, time.Duration, cache.Indexers) cache.SharedIndexInformer) (map[string]cache.SharedIndexInformer, error) {
+	mainInf, err := c.MainInformer(config, gvk, f)
+	if err != nil {
+		return nil, err
+	}
+	return c.multiClusterInformers(config, gvk, mainInf, func(cluster Cluster) cache.SharedIndexInformer {
+		return newNamespacedInformer(config, cluster.MainClient, f)
+	})
+}
+
+// MultiClusterFilteredInformer is like MultiClusterInformer but it restricts objects in all clusters
+// the same way as MainFilteredInformer.
+func (c *Context) MultiClusterFilteredInformer(config *Config, gvk schema.GroupVersionKind, f func(kubernetes.Interface, string, time.Duration, cache.Indexers, internalinterfaces.TweakListOptionsFunc) cache.SharedIndexInformer) (map[string]cache.SharedIndexInformer, error) {
+	mainInf, err := c.MainFilteredInformer(config, gvk, f)
+	if err != nil {
+		return nil, err
+	}
+	selector := c.informerSelector(config, gvk)
+	return c.multiClusterInformers(config, gvk, mainInf, func(cluster Cluster) cache.SharedIndexInformer {
+		return newFilteredNamespacedInformer(config, cluster.MainClient, selector, f)
+	})
+}
+
+// MultiClusterTransformedInformer is like MultiClusterInformer but it restricts and transforms objects in all
+// clusters the same way as MainTransformedInformer.
+func (c *Context) MultiClusterTransformedInformer(config *Config, gvk schema.GroupVersionKind, objType runtime.Object, newListWatch func(kubernetes.Interface, string, internalinterfaces.TweakListOptionsFunc) cache.ListerWatcher) (map[string]cache.SharedIndexInformer, error) {
+	mainInf, err := c.MainTransformedInformer(config, gvk, objType, newListWatch)
+	if err != nil {
+		return nil, err
+	}
+	selector := c.informerSelector(config, gvk)
+	return c.multiClusterInformers(config, gvk, mainInf, func(cluster Cluster) cache.SharedIndexInformer {
+		return newListWatchInformer(config, cluster.MainClient, selector, objType, true, func(namespace string, tweak internalinterfaces.TweakListOptionsFunc) cache.ListerWatcher {
+			return newListWatch(cluster.MainClient, namespace, tweak)
+		})
+	})
+}
+
+// multiClusterInformers registers informers for gvk in all additional clusters that have not been
+// registered yet using newInformer. Returns informers by cluster name including mainInf.
+func (c *Context) multiClusterInformers(config *Config, gvk schema.GroupVersionKind, mainInf cache.SharedIndexInformer, newInformer func(Cluster) cache.SharedIndexInformer) (map[string]cache.SharedIndexInformer, error) {
+	informers := map[string]cache.SharedIndexInformer{
+		"": mainInf,
+	}
+	for _, cluster := range config.Clusters {
+		inf := c.ClusterInformers[cluster.Name][gvk]
+		if inf == nil {
+			inf = newInformer(cluster)
+			if err := c.RegisterClusterInformer(cluster.Name, gvk, inf); err != nil {
+				return nil, err
+			}
+		}
+		informers[cluster.Name] = inf
+	}
+	return informers, nil
+}
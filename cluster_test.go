@@ -0,0 +1,110 @@
+package ctrl
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+	core_v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	core_v1inf "k8s.io/client-go/informers/core/v1"
+	"k8s.io/client-go/informers/internalinterfaces"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+var configMapGvk = core_v1.SchemeGroupVersion.WithKind("ConfigMap")
+
+func newClusterTestConfig(t *testing.T) *Config {
+	newClient := func() kubernetes.Interface {
+		return fake.NewSimpleClientset(
+			&core_v1.ConfigMap{ObjectMeta: meta_v1.ObjectMeta{
+				Namespace:   "ns",
+				Name:        "selected",
+				Labels:      map[string]string{"app": "a"},
+				Annotations: map[string]string{"large": "x", "small": "y"},
+			}},
+			&core_v1.ConfigMap{ObjectMeta: meta_v1.ObjectMeta{
+				Namespace: "ns",
+				Name:      "other",
+				Labels:    map[string]string{"app": "b"},
+			}},
+		)
+	}
+	return &Config{
+		Logger:            zaptest.NewLogger(t),
+		Namespace:         "ns",
+		ResyncPeriod:      time.Hour,
+		InformerSelector:  Selector{LabelSelector: "app=a"},
+		InformerTransform: StripAnnotations("large"),
+		MainClient:        newClient(),
+		Clusters: []Cluster{
+			{
+				Name:       "other",
+				MainClient: newClient(),
+			},
+		},
+	}
+}
+
+func runInformers(t *testing.T, informers map[string]cache.SharedIndexInformer) {
+	stopCh := make(chan struct{})
+	t.Cleanup(func() {
+		close(stopCh)
+	})
+	for _, inf := range informers {
+		go inf.Run(stopCh)
+		require.True(t, cache.WaitForCacheSync(stopCh, inf.HasSynced))
+	}
+}
+
+func TestMultiClusterFilteredInformerRestrictsObjectsInAllClusters(t *testing.T) {
+	t.Parallel()
+
+	config := newClusterTestConfig(t)
+	cctx := &Context{}
+	informers, err := cctx.MultiClusterFilteredInformer(config, configMapGvk, core_v1inf.NewFilteredConfigMapInformer)
+	require.NoError(t, err)
+	require.Len(t, informers, 2)
+	assert.Equal(t, informers["other"], cctx.ClusterInformers["other"][configMapGvk])
+	runInformers(t, informers)
+
+	for cluster, inf := range informers {
+		assert.Equal(t, []string{"ns/selected"}, inf.GetStore().ListKeys(), cluster)
+	}
+}
+
+func TestMultiClusterTransformedInformerTransformsObjectsInAllClusters(t *testing.T) {
+	t.Parallel()
+
+	config := newClusterTestConfig(t)
+	cctx := &Context{}
+	informers, err := cctx.MultiClusterTransformedInformer(config, configMapGvk, &core_v1.ConfigMap{}, func(client kubernetes.Interface, namespace string, tweak internalinterfaces.TweakListOptionsFunc) cache.ListerWatcher {
+		return &cache.ListWatch{
+			ListFunc: func(options meta_v1.ListOptions) (runtime.Object, error) {
+				tweak(&options)
+				return client.CoreV1().ConfigMaps(namespace).List(options)
+			},
+			WatchFunc: func(options meta_v1.ListOptions) (watch.Interface, error) {
+				tweak(&options)
+				return client.CoreV1().ConfigMaps(namespace).Watch(options)
+			},
+		}
+	})
+	require.NoError(t, err)
+	require.Len(t, informers, 2)
+	runInformers(t, informers)
+
+	for cluster, inf := range informers {
+		assert.Equal(t, []string{"ns/selected"}, inf.GetStore().ListKeys(), cluster)
+		obj, exists, err := inf.GetStore().GetByKey("ns/selected")
+		require.NoError(t, err)
+		require.True(t, exists, cluster)
+		assert.Equal(t, map[string]string{"small": "y"}, obj.(*core_v1.ConfigMap).Annotations, cluster)
+	}
+}
@@ -0,0 +1,68 @@
+package ctrl
+
+// ProcessError is an error returned from Interface.Process, classified as external and/or retriable.
+type ProcessError struct {
+	Err error
+	// External is true if the error is not an internal error, e.g. it was caused by invalid user input.
+	External bool
+	// Retriable is true if the object should be added back to the work queue.
+	// Objects are retried according to the controller's RetryPolicy.
+	Retriable bool
+}
+
+func (e *ProcessError) Error() string {
+	return e.Err.Error()
+}
+
+// Cause returns the underlying error. Makes ProcessError work with github.com/pkg/errors.Cause().
+func (e *ProcessError) Cause() error {
+	return e.Err
+}
+
+func (e *ProcessError) Unwrap() error {
+	return e.Err
+}
+
+// NewExternalError returns an external, non-retriable error.
+func NewExternalError(err error) error {
+	return &ProcessError{
+		Err:      err,
+		External: true,
+	}
+}
+
+// NewRetriableError returns an internal, retriable error.
+func NewRetriableError(err error) error {
+	return &ProcessError{
+		Err:       err,
+		Retriable: true,
+	}
+}
+
+// NewExternalRetriableError returns an external, retriable error.
+func NewExternalRetriableError(err error) error {
+	return &ProcessError{
+		Err:       err,
+		External:  true,
+		Retriable: true,
+	}
+}
+
+// ClassifyError returns classification of the first ProcessError in the chain of wrapped errors.
+// Errors without a ProcessError are internal and non-retriable.
+func ClassifyError(err error) (external bool, retriable bool) {
+	for err != nil {
+		if processErr, ok := err.(*ProcessError); ok {
+			return processErr.External, processErr.Retriable
+		}
+		switch e := err.(type) {
+		case interface{ Cause() error }:
+			err = e.Cause()
+		case interface{ Unwrap() error }:
+			err = e.Unwrap()
+		default:
+			return false, false
+		}
+	}
+	return false, false
+}
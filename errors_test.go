@@ -0,0 +1,57 @@
+package ctrl
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClassifyError(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name      string
+		err       error
+		external  bool
+		retriable bool
+	}{
+		{
+			name: "plain error",
+			err:  errors.New("boom"),
+		},
+		{
+			name:     "external error",
+			err:      NewExternalError(errors.New("boom")),
+			external: true,
+		},
+		{
+			name:      "wrapped retriable error",
+			err:       errors.Wrap(NewRetriableError(errors.New("boom")), "failed to do things"),
+			retriable: true,
+		},
+		{
+			name:      "stdlib wrapped external retriable error",
+			err:       fmt.Errorf("failed to do things: %w", NewExternalRetriableError(errors.New("boom"))),
+			external:  true,
+			retriable: true,
+		},
+		{
+			name:      "process error literal",
+			err:       &ProcessError{Err: errors.New("boom"), Retriable: true},
+			retriable: true,
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+
+			external, retriable := ClassifyError(c.err)
+			assert.Equal(t, c.external, external)
+			assert.Equal(t, c.retriable, retriable)
+		})
+	}
+}
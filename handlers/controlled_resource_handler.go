@@ -1,6 +1,8 @@
 package handlers
 
 import (
+	"time"
+
 	"github.com/atlassian/ctrl"
 	"github.com/atlassian/ctrl/logz"
 	"go.uber.org/zap"
@@ -29,11 +31,21 @@ type ControlledResourceHandler struct {
 	ControllerIndex ControllerIndex
 	ControllerGvk   schema.GroupVersionKind
 	Gvk             schema.GroupVersionKind
+	// Cluster is the name of the cluster the informer watches. Empty for the main cluster.
+	// Controller objects are enqueued for the same cluster.
+	Cluster string
 }
 
-func (g *ControlledResourceHandler) enqueueMapped(logger *zap.Logger, metaObj meta_v1.Object) {
+func (g *ControlledResourceHandler) enqueueMapped(logger *zap.Logger, operation ctrl.Operation, metaObj meta_v1.Object) {
 	name, namespace := g.getControllerNameAndNamespace(metaObj)
 	logger = g.loggerForObj(logger, metaObj)
+	cause := ctrl.EnqueueCause{
+		Time:      time.Now(),
+		Operation: operation,
+		Gvk:       g.Gvk,
+		Namespace: metaObj.GetNamespace(),
+		Name:      metaObj.GetName(),
+	}
 
 	if name == "" {
 		if g.ControllerIndex != nil {
@@ -45,18 +57,18 @@ func (g *ControlledResourceHandler) enqueueMapped(logger *zap.Logger, metaObj me
 			}
 			for _, controller := range controllers {
 				controllerMeta := controller.(meta_v1.Object)
-				g.rebuildControllerByName(logger, controllerMeta.GetNamespace(), controllerMeta.GetName())
+				g.rebuildControllerByName(logger, cause, controllerMeta.GetNamespace(), controllerMeta.GetName())
 			}
 		}
 	} else {
-		g.rebuildControllerByName(logger, namespace, name)
+		g.rebuildControllerByName(logger, cause, namespace, name)
 	}
 }
 
 func (g *ControlledResourceHandler) OnAdd(obj interface{}) {
 	metaObj := obj.(meta_v1.Object)
 	logger := g.Logger.With(logz.Operation(ctrl.AddedOperation))
-	g.enqueueMapped(logger, metaObj)
+	g.enqueueMapped(logger, ctrl.AddedOperation, metaObj)
 }
 
 func (g *ControlledResourceHandler) OnUpdate(oldObj, newObj interface{}) {
@@ -68,10 +80,10 @@ func (g *ControlledResourceHandler) OnUpdate(oldObj, newObj interface{}) {
 	newName, _ := g.getControllerNameAndNamespace(newMeta)
 
 	if oldName != newName {
-		g.enqueueMapped(logger, oldMeta)
+		g.enqueueMapped(logger, ctrl.UpdatedOperation, oldMeta)
 	}
 
-	g.enqueueMapped(logger, newMeta)
+	g.enqueueMapped(logger, ctrl.UpdatedOperation, newMeta)
 }
 
 func (g *ControlledResourceHandler) OnDelete(obj interface{}) {
@@ -89,11 +101,11 @@ func (g *ControlledResourceHandler) OnDelete(obj interface{}) {
 			return
 		}
 	}
-	g.enqueueMapped(logger, metaObj)
+	g.enqueueMapped(logger, ctrl.DeletedOperation, metaObj)
 }
 
 // This method may be called with an empty controllerName.
-func (g *ControlledResourceHandler) rebuildControllerByName(logger *zap.Logger, namespace, controllerName string) {
+func (g *ControlledResourceHandler) rebuildControllerByName(logger *zap.Logger, cause ctrl.EnqueueCause, namespace, controllerName string) {
 	if controllerName == "" {
 		logger.Debug("Object has no controller, so nothing was enqueued")
 		return
@@ -102,10 +114,11 @@ func (g *ControlledResourceHandler) rebuildControllerByName(logger *zap.Logger,
 		With(logz.DelegateName(controllerName)).
 		With(logz.DelegateGk(g.ControllerGvk.GroupKind())).
 		Info("Enqueuing controller")
-	g.WorkQueue.Add(ctrl.QueueKey{
+	ctrl.AddWithCause(g.WorkQueue, ctrl.QueueKey{
+		Cluster:   g.Cluster,
 		Namespace: namespace,
 		Name:      controllerName,
-	})
+	}, cause)
 }
 
 // getControllerNameAndNamespace returns name and namespace of the object's controller.
@@ -120,7 +133,8 @@ func (g *ControlledResourceHandler) getControllerNameAndNamespace(obj meta_v1.Ob
 }
 
 func (g *ControlledResourceHandler) loggerForObj(logger *zap.Logger, obj meta_v1.Object) *zap.Logger {
-	return logger.With(logz.Namespace(obj),
+	return logger.With(logz.Cluster(g.Cluster),
+		logz.Namespace(obj),
 		logz.Object(obj),
 		logz.ObjectGk(g.Gvk.GroupKind()))
 }
@@ -1,35 +1,47 @@
 package handlers
 
 import (
+	"time"
+
 	"github.com/atlassian/ctrl"
 	"github.com/atlassian/ctrl/logz"
 	"go.uber.org/zap"
 	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/client-go/tools/cache"
 )
 
+// DeletedObjectStore stores the last known state of deleted objects.
+type DeletedObjectStore interface {
+	Put(ctrl.QueueKey, runtime.Object)
+}
+
 // This handler assumes that the Logger already has the obj_gk/ctrl_gk field set.
 type GenericHandler struct {
 	Logger    *zap.Logger
 	WorkQueue ctrl.WorkQueueProducer
+	// DeletedObjects is an optional store for the last known state of deleted objects.
+	DeletedObjects DeletedObjectStore
+	// Cluster is the name of the cluster the informer watches. Empty for the main cluster.
+	Cluster string
 
 	Gvk schema.GroupVersionKind
 }
 
 func (g *GenericHandler) OnAdd(obj interface{}) {
-	logger := g.Logger.With(logz.Operation("added"))
-	g.add(logger, obj.(meta_v1.Object))
+	logger := g.Logger.With(logz.Operation(ctrl.AddedOperation))
+	g.add(logger, ctrl.AddedOperation, obj.(meta_v1.Object))
 }
 
 func (g *GenericHandler) OnUpdate(oldObj, newObj interface{}) {
-	logger := g.Logger.With(logz.Operation("updated"))
-	g.add(logger, newObj.(meta_v1.Object))
+	logger := g.Logger.With(logz.Operation(ctrl.UpdatedOperation))
+	g.add(logger, ctrl.UpdatedOperation, newObj.(meta_v1.Object))
 }
 
 func (g *GenericHandler) OnDelete(obj interface{}) {
 	metaObj, ok := obj.(meta_v1.Object)
-	logger := g.Logger.With(logz.Operation("deleted"))
+	logger := g.Logger.With(logz.Operation(ctrl.DeletedOperation))
 	if !ok {
 		tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
 		if !ok {
@@ -42,19 +54,34 @@ func (g *GenericHandler) OnDelete(obj interface{}) {
 			return
 		}
 	}
-	g.add(logger, metaObj)
+	if g.DeletedObjects != nil {
+		g.DeletedObjects.Put(ctrl.QueueKey{
+			Cluster:   g.Cluster,
+			Namespace: metaObj.GetNamespace(),
+			Name:      metaObj.GetName(),
+		}, metaObj.(runtime.Object))
+	}
+	g.add(logger, ctrl.DeletedOperation, metaObj)
 }
 
-func (g *GenericHandler) add(logger *zap.Logger, obj meta_v1.Object) {
+func (g *GenericHandler) add(logger *zap.Logger, operation ctrl.Operation, obj meta_v1.Object) {
 	g.loggerForObj(logger, obj).Info("Enqueuing object")
-	g.WorkQueue.Add(ctrl.QueueKey{
+	ctrl.AddWithCause(g.WorkQueue, ctrl.QueueKey{
+		Cluster:   g.Cluster,
+		Namespace: obj.GetNamespace(),
+		Name:      obj.GetName(),
+	}, ctrl.EnqueueCause{
+		Time:      time.Now(),
+		Operation: operation,
+		Gvk:       g.Gvk,
 		Namespace: obj.GetNamespace(),
 		Name:      obj.GetName(),
 	})
 }
 
 func (g *GenericHandler) loggerForObj(logger *zap.Logger, obj meta_v1.Object) *zap.Logger {
-	return logger.With(logz.Namespace(obj),
+	return logger.With(logz.Cluster(g.Cluster),
+		logz.Namespace(obj),
 		logz.Object(obj),
 		logz.ObjectGk(g.Gvk.GroupKind()))
 }
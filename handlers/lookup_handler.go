@@ -1,6 +1,8 @@
 package handlers
 
 import (
+	"time"
+
 	"github.com/atlassian/ctrl"
 	"github.com/atlassian/ctrl/logz"
 	"go.uber.org/zap"
@@ -17,12 +19,22 @@ type LookupHandler struct {
 	Logger    *zap.Logger
 	WorkQueue ctrl.WorkQueueProducer
 	Gvk       schema.GroupVersionKind
+	// Cluster is the name of the cluster the informer watches. Empty for the main cluster.
+	// Looked up objects are enqueued for the same cluster.
+	Cluster string
 
 	Lookup func(runtime.Object) ([]runtime.Object, error)
 }
 
-func (e *LookupHandler) enqueueMapped(logger *zap.Logger, obj meta_v1.Object) {
+func (e *LookupHandler) enqueueMapped(logger *zap.Logger, operation ctrl.Operation, obj meta_v1.Object) {
 	logger = e.loggerForObj(logger, obj)
+	cause := ctrl.EnqueueCause{
+		Time:      time.Now(),
+		Operation: operation,
+		Gvk:       e.Gvk,
+		Namespace: obj.GetNamespace(),
+		Name:      obj.GetName(),
+	}
 	objs, err := e.Lookup(obj.(runtime.Object))
 	if err != nil {
 		logger.Error("Failed to lookup objects", zap.Error(err))
@@ -37,21 +49,22 @@ func (e *LookupHandler) enqueueMapped(logger *zap.Logger, obj meta_v1.Object) {
 			With(logz.DelegateName(obj.GetName())).
 			With(logz.DelegateGk(e.Gvk.GroupKind())).
 			Info("Enqueuing looked up object")
-		e.WorkQueue.Add(ctrl.QueueKey{
+		ctrl.AddWithCause(e.WorkQueue, ctrl.QueueKey{
+			Cluster:   e.Cluster,
 			Namespace: metaobj.GetNamespace(),
 			Name:      metaobj.GetName(),
-		})
+		}, cause)
 	}
 }
 
 func (e *LookupHandler) OnAdd(obj interface{}) {
 	logger := e.Logger.With(logz.Operation(ctrl.AddedOperation))
-	e.enqueueMapped(logger, obj.(meta_v1.Object))
+	e.enqueueMapped(logger, ctrl.AddedOperation, obj.(meta_v1.Object))
 }
 
 func (e *LookupHandler) OnUpdate(oldObj, newObj interface{}) {
 	logger := e.Logger.With(logz.Operation(ctrl.UpdatedOperation))
-	e.enqueueMapped(logger, newObj.(meta_v1.Object))
+	e.enqueueMapped(logger, ctrl.UpdatedOperation, newObj.(meta_v1.Object))
 }
 
 func (e *LookupHandler) OnDelete(obj interface{}) {
@@ -69,12 +82,13 @@ func (e *LookupHandler) OnDelete(obj interface{}) {
 			return
 		}
 	}
-	e.enqueueMapped(logger, metaObj)
+	e.enqueueMapped(logger, ctrl.DeletedOperation, metaObj)
 }
 
 // loggerForObj returns a logger with fields for a controlled object.
 func (e *LookupHandler) loggerForObj(logger *zap.Logger, obj meta_v1.Object) *zap.Logger {
-	return logger.With(logz.Namespace(obj),
+	return logger.With(logz.Cluster(e.Cluster),
+		logz.Namespace(obj),
 		logz.Object(obj),
 		logz.ObjectGk(e.Gvk.GroupKind()))
 }
@@ -0,0 +1,28 @@
+package ctrl
+
+import (
+	"strings"
+
+	"github.com/atlassian/ctrl/healthz"
+	"github.com/pkg/errors"
+)
+
+// AddLivenessCheck registers a health check that fails if the process should be restarted.
+// The check is named "<groupkind>/<name>" after the controller's GroupKind e.g. "configmap/database".
+func (c *Context) AddLivenessCheck(name string, check healthz.CheckFunc) error {
+	return c.addHealthCheck(name, healthz.Liveness, check)
+}
+
+// AddReadinessCheck registers a health check that fails if the process should not receive traffic.
+// The check is named "<groupkind>/<name>" after the controller's GroupKind e.g. "configmap/webhook-cert".
+func (c *Context) AddReadinessCheck(name string, check healthz.CheckFunc) error {
+	return c.addHealthCheck(name, healthz.Readiness, check)
+}
+
+func (c *Context) addHealthCheck(name string, kind healthz.Kind, check healthz.CheckFunc) error {
+	if c.HealthChecks == nil {
+		return errors.New("health checks are not supported")
+	}
+	groupKind := c.Descriptor.Gvk.GroupKind()
+	return c.HealthChecks.AddCheck(strings.ToLower(groupKind.String())+"/"+name, kind, check)
+}
@@ -0,0 +1,223 @@
+package healthz
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+const (
+	metricsNamespace = "ctrl"
+)
+
+// Kind of a health check.
+type Kind string
+
+const (
+	// Liveness checks fail if the process should be restarted. They are included in all endpoints.
+	Liveness Kind = "liveness"
+	// Readiness checks fail if the process should not receive traffic. They are included in /readyz and /healthz.
+	Readiness Kind = "readiness"
+)
+
+// CheckFunc returns an error if the check fails.
+type CheckFunc func(ctx context.Context) error
+
+type check struct {
+	name  string
+	kind  Kind
+	check CheckFunc
+}
+
+// Registry holds named health checks and serves them on /healthz, /livez and /readyz.
+// Endpoints respond with "ok" if all checks pass. Query parameter "verbose" lists the status of each check.
+// Query parameter "exclude" can be repeated to exclude checks by name. Status of each check is exported
+// as a Prometheus gauge. The exported status is the result of the last time the check was run by an endpoint,
+// checks are not run to collect metrics.
+type Registry struct {
+	mu     sync.RWMutex
+	checks map[string]check
+	// passed holds the result of the last run of each check that has run.
+	passed map[string]bool
+
+	status *prometheus.Desc
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		checks: make(map[string]check),
+		passed: make(map[string]bool),
+		status: prometheus.NewDesc(
+			prometheus.BuildFQName(metricsNamespace, "", "health_check_status"),
+			"Status of a health check. 1 if the check passes, 0 if it fails",
+			[]string{"check", "kind"}, nil,
+		),
+	}
+}
+
+// AddCheck registers a health check. Names must be unique.
+func (r *Registry) AddCheck(name string, kind Kind, f CheckFunc) error {
+	if name == "" {
+		return errors.New("health check must have a name")
+	}
+	if strings.ContainsAny(name, ",?&") {
+		return errors.Errorf("health check name %q must not contain ',', '?' or '&'", name)
+	}
+	switch kind {
+	case Liveness, Readiness:
+	default:
+		return errors.Errorf("invalid kind %q of health check %q", kind, name)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.checks[name]; ok {
+		return errors.Errorf("health check %q has been registered already", name)
+	}
+	r.checks[name] = check{
+		name:  name,
+		kind:  kind,
+		check: f,
+	}
+	return nil
+}
+
+// checksFor returns checks of the kinds sorted by name.
+func (r *Registry) checksFor(kinds ...Kind) []check {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var checks []check
+	for _, c := range r.checks {
+		for _, kind := range kinds {
+			if c.kind == kind {
+				checks = append(checks, c)
+				break
+			}
+		}
+	}
+	sort.Slice(checks, func(i, j int) bool {
+		return checks[i].name < checks[j].name
+	})
+	return checks
+}
+
+// run runs the check and records its result.
+func (r *Registry) run(ctx context.Context, c check) error {
+	err := c.check(ctx)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.passed[c.name] = err == nil
+	return err
+}
+
+// HealthzHandler serves all checks.
+func (r *Registry) HealthzHandler(logger *zap.Logger) http.Handler {
+	return r.handler(logger, "healthz", Liveness, Readiness)
+}
+
+// LivezHandler serves liveness checks.
+func (r *Registry) LivezHandler(logger *zap.Logger) http.Handler {
+	return r.handler(logger, "livez", Liveness)
+}
+
+// ReadyzHandler serves liveness and readiness checks.
+func (r *Registry) ReadyzHandler(logger *zap.Logger) http.Handler {
+	return r.handler(logger, "readyz", Liveness, Readiness)
+}
+
+// CheckHandler serves a single check of one of the kinds. Name of the check is the path of the request
+// relative to the prefix.
+func (r *Registry) CheckHandler(logger *zap.Logger, prefix string, kinds ...Kind) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		name := strings.TrimPrefix(req.URL.Path, prefix)
+		for _, c := range r.checksFor(kinds...) {
+			if c.name != name {
+				continue
+			}
+			if err := r.run(req.Context(), c); err != nil {
+				logger.Info("Health check failed", zap.String("check", c.name), zap.Error(err))
+				http.Error(w, fmt.Sprintf("internal server error: %s check failed", c.name), http.StatusInternalServerError)
+				return
+			}
+			fmt.Fprint(w, "ok") // nolint: errcheck
+			return
+		}
+		http.NotFound(w, req)
+	})
+}
+
+func (r *Registry) handler(logger *zap.Logger, endpoint string, kinds ...Kind) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		excluded := make(map[string]struct{})
+		for _, names := range req.URL.Query()["exclude"] {
+			for _, name := range strings.Split(names, ",") {
+				if name = strings.TrimSpace(name); name != "" {
+					excluded[name] = struct{}{}
+				}
+			}
+		}
+		var output bytes.Buffer
+		failed := false
+		for _, c := range r.checksFor(kinds...) {
+			if _, ok := excluded[c.name]; ok {
+				delete(excluded, c.name)
+				fmt.Fprintf(&output, "[+]%s excluded: ok\n", c.name) // nolint: errcheck
+				continue
+			}
+			if err := r.run(req.Context(), c); err != nil {
+				logger.Info("Health check failed", zap.String("check", c.name), zap.Error(err))
+				// Reason is not exposed to avoid leaking sensitive information
+				fmt.Fprintf(&output, "[-]%s failed: reason withheld\n", c.name) // nolint: errcheck
+				failed = true
+			} else {
+				fmt.Fprintf(&output, "[+]%s ok\n", c.name) // nolint: errcheck
+			}
+		}
+		if len(excluded) > 0 {
+			names := make([]string, 0, len(excluded))
+			for name := range excluded {
+				names = append(names, fmt.Sprintf("%q", name))
+			}
+			sort.Strings(names)
+			fmt.Fprintf(&output, "warn: some health checks cannot be excluded: no matches for %s\n", strings.Join(names, ",")) // nolint: errcheck
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		if failed {
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintf(&output, "%s check failed\n", endpoint) // nolint: errcheck
+			output.WriteTo(w)                                   // nolint: errcheck, gosec
+			return
+		}
+		if _, verbose := req.URL.Query()["verbose"]; verbose {
+			fmt.Fprintf(&output, "%s check passed\n", endpoint) // nolint: errcheck
+			output.WriteTo(w)                                   // nolint: errcheck, gosec
+			return
+		}
+		fmt.Fprint(w, "ok") // nolint: errcheck
+	})
+}
+
+func (r *Registry) Describe(ch chan<- *prometheus.Desc) {
+	ch <- r.status
+}
+
+// Collect exports the status of checks that have run.
+func (r *Registry) Collect(ch chan<- prometheus.Metric) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for name, passed := range r.passed {
+		var value float64
+		if passed {
+			value = 1
+		}
+		ch <- prometheus.MustNewConstMetric(r.status, prometheus.GaugeValue, value, name, string(r.checks[name].kind))
+	}
+}
@@ -0,0 +1,111 @@
+package healthz
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+)
+
+func testRegistry(t *testing.T) *Registry {
+	r := NewRegistry()
+	require.NoError(t, r.AddCheck("ping", Liveness, func(context.Context) error {
+		return nil
+	}))
+	require.NoError(t, r.AddCheck("database", Readiness, func(context.Context) error {
+		return errors.New("connection refused")
+	}))
+	return r
+}
+
+func get(h http.Handler, url string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, url, nil))
+	return w
+}
+
+func TestRegistryRejectsDuplicateChecks(t *testing.T) {
+	t.Parallel()
+
+	r := testRegistry(t)
+	assert.Error(t, r.AddCheck("ping", Readiness, func(context.Context) error {
+		return nil
+	}))
+}
+
+func TestLivezIncludesOnlyLivenessChecks(t *testing.T) {
+	t.Parallel()
+
+	r := testRegistry(t)
+	logger := zaptest.NewLogger(t)
+
+	w := get(r.LivezHandler(logger), "/livez")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "ok", w.Body.String())
+
+	w = get(r.LivezHandler(logger), "/livez?verbose")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "[+]ping ok\nlivez check passed\n", w.Body.String())
+}
+
+func TestReadyzFailsAndWithholdsReason(t *testing.T) {
+	t.Parallel()
+
+	r := testRegistry(t)
+	w := get(r.ReadyzHandler(zaptest.NewLogger(t)), "/readyz")
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, "[-]database failed: reason withheld\n[+]ping ok\nreadyz check failed\n", w.Body.String())
+	assert.NotContains(t, w.Body.String(), "connection refused")
+}
+
+func TestHealthzExclude(t *testing.T) {
+	t.Parallel()
+
+	r := testRegistry(t)
+	w := get(r.HealthzHandler(zaptest.NewLogger(t)), "/healthz?verbose&exclude=database&exclude=unknown")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "[+]database excluded: ok\n[+]ping ok\n"+
+		"warn: some health checks cannot be excluded: no matches for \"unknown\"\nhealthz check passed\n", w.Body.String())
+}
+
+func TestCheckHandler(t *testing.T) {
+	t.Parallel()
+
+	r := testRegistry(t)
+	logger := zaptest.NewLogger(t)
+
+	w := get(r.CheckHandler(logger, "/readyz/", Liveness, Readiness), "/readyz/ping")
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = get(r.CheckHandler(logger, "/readyz/", Liveness, Readiness), "/readyz/database")
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	w = get(r.CheckHandler(logger, "/livez/", Liveness), "/livez/database")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestRegistryExportsStatus(t *testing.T) {
+	t.Parallel()
+
+	r := testRegistry(t)
+	registry := prometheus.NewPedanticRegistry()
+	require.NoError(t, registry.Register(r))
+
+	// Checks are not run to collect metrics
+	assert.NoError(t, testutil.GatherAndCompare(registry, strings.NewReader(""), "ctrl_health_check_status"))
+	get(r.ReadyzHandler(zaptest.NewLogger(t)), "/readyz")
+
+	expected := `
+# HELP ctrl_health_check_status Status of a health check. 1 if the check passes, 0 if it fails
+# TYPE ctrl_health_check_status gauge
+ctrl_health_check_status{check="database",kind="readiness"} 0
+ctrl_health_check_status{check="ping",kind="liveness"} 1
+`
+	assert.NoError(t, testutil.GatherAndCompare(registry, strings.NewReader(expected), "ctrl_health_check_status"))
+}
@@ -0,0 +1,338 @@
+package loglevel
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// ObjectKey identifies an object processed by a controller.
+type ObjectKey struct {
+	// Cluster is the name of the cluster the object is in. Empty for the main cluster.
+	Cluster   string
+	Namespace string
+	Name      string
+}
+
+type objectKey struct {
+	gvk schema.GroupVersionKind
+	ObjectKey
+}
+
+// Registry holds the default log level and levels of controllers and objects that override it. Levels
+// can be changed at runtime. Loggers that are constructed by the registry ignore the level of the core
+// of the logger they wrap, so that core must enable all levels.
+type Registry struct {
+	level zap.AtomicLevel
+
+	mu          sync.RWMutex
+	controllers map[schema.GroupVersionKind]*controllerLevel
+	objects     map[objectKey]zapcore.Level
+}
+
+func NewRegistry(level zapcore.Level) *Registry {
+	return &Registry{
+		level:       zap.NewAtomicLevelAt(level),
+		controllers: make(map[schema.GroupVersionKind]*controllerLevel),
+		objects:     make(map[objectKey]zapcore.Level),
+	}
+}
+
+// controllerLevel is the level of a controller's logger. The default level is used unless it is overridden.
+type controllerLevel struct {
+	defaultLevel zap.AtomicLevel
+	level        zap.AtomicLevel
+	// overridden is set to 1 if level overrides the default level.
+	overridden int32
+}
+
+func (l *controllerLevel) Enabled(lvl zapcore.Level) bool {
+	if atomic.LoadInt32(&l.overridden) != 0 {
+		return l.level.Enabled(lvl)
+	}
+	return l.defaultLevel.Enabled(lvl)
+}
+
+// Level returns the default log level.
+func (r *Registry) Level() zapcore.Level {
+	return r.level.Level()
+}
+
+// SetLevel changes the default log level.
+func (r *Registry) SetLevel(level zapcore.Level) {
+	r.level.SetLevel(level)
+}
+
+// Logger returns a logger that logs at the default level.
+func (r *Registry) Logger(logger *zap.Logger) *zap.Logger {
+	return withLevel(logger, r.level)
+}
+
+// ControllerLogger registers the controller and returns a logger for it. Can be called on a nil pointer,
+// in which case the logger is returned as is.
+func (r *Registry) ControllerLogger(logger *zap.Logger, gvk schema.GroupVersionKind) *zap.Logger {
+	if r == nil {
+		return logger
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	level, ok := r.controllers[gvk]
+	if !ok {
+		level = &controllerLevel{
+			defaultLevel: r.level,
+			level:        zap.NewAtomicLevel(),
+		}
+		r.controllers[gvk] = level
+	}
+	return withLevel(logger, level)
+}
+
+// ObjectLogger returns a logger for processing of the object if the object's level has been overridden.
+// Otherwise, the logger is returned as is. The logger must have been constructed by the registry.
+// Can be called on a nil pointer.
+func (r *Registry) ObjectLogger(logger *zap.Logger, gvk schema.GroupVersionKind, key ObjectKey) *zap.Logger {
+	if r == nil {
+		return logger
+	}
+	r.mu.RLock()
+	level, ok := r.objects[objectKey{gvk: gvk, ObjectKey: key}]
+	r.mu.RUnlock()
+	if !ok {
+		return logger
+	}
+	return withLevel(logger, level)
+}
+
+// SetControllerLevel overrides the default level for the controller.
+func (r *Registry) SetControllerLevel(gvk schema.GroupVersionKind, level zapcore.Level) error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	controller, ok := r.controllers[gvk]
+	if !ok {
+		return errors.Errorf("no controller for GVK %s", gvk)
+	}
+	controller.level.SetLevel(level)
+	atomic.StoreInt32(&controller.overridden, 1)
+	return nil
+}
+
+// ResetControllerLevel makes the controller use the default level.
+func (r *Registry) ResetControllerLevel(gvk schema.GroupVersionKind) error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	controller, ok := r.controllers[gvk]
+	if !ok {
+		return errors.Errorf("no controller for GVK %s", gvk)
+	}
+	atomic.StoreInt32(&controller.overridden, 0)
+	return nil
+}
+
+// SetObjectLevel overrides the level of the controller when it processes the object. It takes effect
+// the next time the object is processed.
+func (r *Registry) SetObjectLevel(gvk schema.GroupVersionKind, key ObjectKey, level zapcore.Level) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.controllers[gvk]; !ok {
+		return errors.Errorf("no controller for GVK %s", gvk)
+	}
+	r.objects[objectKey{gvk: gvk, ObjectKey: key}] = level
+	return nil
+}
+
+// ResetObjectLevel makes the controller use its own level when it processes the object.
+func (r *Registry) ResetObjectLevel(gvk schema.GroupVersionKind, key ObjectKey) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.objects, objectKey{gvk: gvk, ObjectKey: key})
+}
+
+// controllerFor returns the GVK of the controller for the group kind.
+func (r *Registry) controllerFor(groupKind string) (schema.GroupVersionKind, error) {
+	gk := schema.ParseGroupKind(groupKind)
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for gvk := range r.controllers {
+		if gvk.GroupKind() == gk {
+			return gvk, nil
+		}
+	}
+	return schema.GroupVersionKind{}, errors.Errorf("no controller for %q", groupKind)
+}
+
+// Levels is the state of the registry.
+type Levels struct {
+	Level string `json:"level"`
+	// Controllers are overridden levels of controllers by group kind.
+	Controllers map[string]string `json:"controllers"`
+	Objects     []ObjectLevel     `json:"objects"`
+}
+
+// ObjectLevel is an overridden level of an object.
+type ObjectLevel struct {
+	Controller string `json:"controller"`
+	Cluster    string `json:"cluster,omitempty"`
+	Namespace  string `json:"namespace,omitempty"`
+	Name       string `json:"name"`
+	Level      string `json:"level"`
+}
+
+// Levels returns the default level and all overridden levels.
+func (r *Registry) Levels() Levels {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	levels := Levels{
+		Level:       r.level.String(),
+		Controllers: make(map[string]string),
+		Objects:     make([]ObjectLevel, 0, len(r.objects)),
+	}
+	for gvk, controller := range r.controllers {
+		if atomic.LoadInt32(&controller.overridden) != 0 {
+			levels.Controllers[gvk.GroupKind().String()] = controller.level.String()
+		}
+	}
+	for key, level := range r.objects {
+		levels.Objects = append(levels.Objects, ObjectLevel{
+			Controller: key.gvk.GroupKind().String(),
+			Cluster:    key.Cluster,
+			Namespace:  key.Namespace,
+			Name:       key.Name,
+			Level:      level.String(),
+		})
+	}
+	sort.Slice(levels.Objects, func(i, j int) bool {
+		a, b := levels.Objects[i], levels.Objects[j]
+		if a.Controller != b.Controller {
+			return a.Controller < b.Controller
+		}
+		if a.Cluster != b.Cluster {
+			return a.Cluster < b.Cluster
+		}
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		return a.Name < b.Name
+	})
+	return levels
+}
+
+// Handler serves the state of the registry on GET and changes levels on PUT and DELETE. Levels are changed
+// using query parameters:
+//   - "level" is the level to set. Required for PUT.
+//   - "controller" is the group kind of the controller e.g. "ConfigMap" or "Deployment.apps". The default level
+//     is changed if it is not set. The default level cannot be reset.
+//   - "namespace", "name" and optional "cluster" identify an object processed by the controller.
+//
+// Responds with the state of the registry.
+func (r *Registry) Handler(logger *zap.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.Method {
+		case http.MethodGet:
+		case http.MethodPut, http.MethodDelete:
+			if err := r.change(req); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			logger.Info("Changed log level", zap.String("request", req.Method+" "+req.URL.RawQuery))
+		default:
+			w.Header().Set("Allow", strings.Join([]string{http.MethodGet, http.MethodPut, http.MethodDelete}, ", "))
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(r.Levels()); err != nil {
+			logger.Debug("Failed to write log levels response", zap.Error(err))
+		}
+	})
+}
+
+func (r *Registry) change(req *http.Request) error {
+	query := req.URL.Query()
+	var level zapcore.Level
+	if req.Method == http.MethodPut {
+		if err := level.UnmarshalText([]byte(query.Get("level"))); err != nil {
+			return errors.Errorf("invalid level %q", query.Get("level"))
+		}
+	}
+	controller := query.Get("controller")
+	key := ObjectKey{
+		Cluster:   query.Get("cluster"),
+		Namespace: query.Get("namespace"),
+		Name:      query.Get("name"),
+	}
+	if controller == "" {
+		if key != (ObjectKey{}) {
+			return errors.New("object requires a controller")
+		}
+		if req.Method == http.MethodDelete {
+			return errors.New("default level cannot be reset")
+		}
+		r.SetLevel(level)
+		return nil
+	}
+	gvk, err := r.controllerFor(controller)
+	if err != nil {
+		return err
+	}
+	if key == (ObjectKey{}) {
+		if req.Method == http.MethodDelete {
+			return r.ResetControllerLevel(gvk)
+		}
+		return r.SetControllerLevel(gvk, level)
+	}
+	if key.Name == "" {
+		return errors.New("object requires a name")
+	}
+	if req.Method == http.MethodDelete {
+		r.ResetObjectLevel(gvk, key)
+		return nil
+	}
+	return r.SetObjectLevel(gvk, key, level)
+}
+
+// withLevel returns a logger that filters entries using the level enabler. It replaces the level enabler
+// of a logger that has been constructed by the registry.
+func withLevel(logger *zap.Logger, level zapcore.LevelEnabler) *zap.Logger {
+	return logger.WithOptions(zap.WrapCore(func(c zapcore.Core) zapcore.Core {
+		if lc, ok := c.(*levelCore); ok {
+			c = lc.Core
+		}
+		return &levelCore{
+			Core:  c,
+			level: level,
+		}
+	}))
+}
+
+// levelCore filters entries using a level enabler instead of the level of the wrapped core.
+type levelCore struct {
+	zapcore.Core
+	level zapcore.LevelEnabler
+}
+
+func (c *levelCore) Enabled(level zapcore.Level) bool {
+	return c.level.Enabled(level)
+}
+
+func (c *levelCore) With(fields []zapcore.Field) zapcore.Core {
+	return &levelCore{
+		Core:  c.Core.With(fields),
+		level: c.level,
+	}
+}
+
+func (c *levelCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.level.Enabled(entry.Level) {
+		// Entry is written by the wrapped core regardless of its level
+		return checked.AddCore(entry, c)
+	}
+	return checked
+}
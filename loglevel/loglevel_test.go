@@ -0,0 +1,102 @@
+package loglevel
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest"
+	"go.uber.org/zap/zaptest/observer"
+	core_v1 "k8s.io/api/core/v1"
+)
+
+var (
+	configMapGvk = core_v1.SchemeGroupVersion.WithKind("ConfigMap")
+	secretGvk    = core_v1.SchemeGroupVersion.WithKind("Secret")
+)
+
+func TestRegistryOverridesLevels(t *testing.T) {
+	t.Parallel()
+
+	core, logs := observer.New(zapcore.DebugLevel)
+	r := NewRegistry(zapcore.InfoLevel)
+	logger := r.Logger(zap.New(core))
+	cmLogger := r.ControllerLogger(logger, configMapGvk).With(zap.String("controller", "cm"))
+	secretLogger := r.ControllerLogger(logger, secretGvk)
+	key := ObjectKey{Namespace: "ns", Name: "cm"}
+
+	logger.Debug("default")
+	cmLogger.Debug("cm")
+	require.NoError(t, r.SetControllerLevel(configMapGvk, zapcore.DebugLevel))
+	cmLogger.Debug("cm overridden")
+	secretLogger.Debug("secret")
+	require.NoError(t, r.ResetControllerLevel(configMapGvk))
+	cmLogger.Debug("cm reset")
+
+	require.NoError(t, r.SetObjectLevel(configMapGvk, key, zapcore.DebugLevel))
+	r.ObjectLogger(cmLogger, configMapGvk, key).Debug("object overridden")
+	r.ObjectLogger(cmLogger, configMapGvk, ObjectKey{Namespace: "ns", Name: "other"}).Debug("other object")
+	r.ResetObjectLevel(configMapGvk, key)
+	r.ObjectLogger(cmLogger, configMapGvk, key).Debug("object reset")
+
+	r.SetLevel(zapcore.DebugLevel)
+	secretLogger.Debug("secret default changed")
+
+	var messages []string
+	for _, entry := range logs.AllUntimed() {
+		messages = append(messages, entry.Message)
+	}
+	assert.Equal(t, []string{"cm overridden", "object overridden", "secret default changed"}, messages)
+	assert.Equal(t, "cm", logs.FilterMessage("object overridden").All()[0].ContextMap()["controller"])
+
+	assert.Error(t, r.SetControllerLevel(core_v1.SchemeGroupVersion.WithKind("Pod"), zapcore.DebugLevel))
+}
+
+func TestHandlerChangesLevels(t *testing.T) {
+	t.Parallel()
+
+	r := NewRegistry(zapcore.InfoLevel)
+	r.ControllerLogger(zap.NewNop(), configMapGvk)
+	handler := r.Handler(zaptest.NewLogger(t))
+
+	serve := func(method, query string) (int, Levels) {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(method, "/debug/loglevel?"+query, nil))
+		var levels Levels
+		if w.Code == http.StatusOK {
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &levels))
+		}
+		return w.Code, levels
+	}
+
+	code, levels := serve(http.MethodPut, "controller=ConfigMap&level=debug")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, map[string]string{"ConfigMap": "debug"}, levels.Controllers)
+
+	code, levels = serve(http.MethodPut, "controller=ConfigMap&namespace=ns&name=cm&level=error")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, []ObjectLevel{{Controller: "ConfigMap", Namespace: "ns", Name: "cm", Level: "error"}}, levels.Objects)
+
+	code, levels = serve(http.MethodDelete, "controller=ConfigMap")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Empty(t, levels.Controllers)
+	assert.Len(t, levels.Objects, 1)
+
+	code, levels = serve(http.MethodPut, "level=warn")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "warn", levels.Level)
+
+	code, _ = serve(http.MethodPut, "controller=Deployment.apps&level=debug")
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = serve(http.MethodPut, "controller=ConfigMap&level=verbose")
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = serve(http.MethodDelete, "")
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = serve(http.MethodPost, "level=debug")
+	assert.Equal(t, http.StatusMethodNotAllowed, code)
+}
@@ -54,6 +54,29 @@ func NamespaceName(namespace string) zapcore.Field {
 	return zap.String("namespace", namespace)
 }
 
+// Cluster is a zap field used to identify the cluster an object is in. Skipped for the main cluster.
+func Cluster(name string) zapcore.Field {
+	if name == "" {
+		return zap.Skip()
+	}
+	return zap.String("cluster", name)
+}
+
+// EnqueueCause is a zap field used to record the informer event that caused an object to be enqueued.
+func EnqueueCause(cause ctrl.EnqueueCause) zapcore.Field {
+	return zap.Stringer("cause", cause)
+}
+
 func Iteration(iteration uint32) zapcore.Field {
 	return zap.Uint32("iter", iteration)
 }
+
+// Finalizer is a zap field used to identify the finalizer that is being added or removed.
+func Finalizer(name string) zapcore.Field {
+	return zap.String("finalizer", name)
+}
+
+// Stack is a zap field used to record the stack trace of a recovered panic.
+func Stack(stack []byte) zapcore.Field {
+	return zap.ByteString("stack", stack)
+}
@@ -0,0 +1,399 @@
+package ctrl
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	core_v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	core_v1inf "k8s.io/client-go/informers/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// multiNamespaceInformer is a SharedIndexInformer that watches a set of namespaces using an informer per namespace
+// and presents them as a single informer. The set of namespaces is either fixed or discovered using a label
+// selector for namespaces. Informers for namespaces that are no longer selected are stopped and their objects
+// disappear from the informer without delete notifications.
+// Store and Indexer of the informer are read only.
+type multiNamespaceInformer struct {
+	logger      *zap.Logger
+	newInformer func(namespace string) cache.SharedIndexInformer
+	// namespaceInformer is used to discover namespaces. Optional.
+	namespaceInformer cache.SharedIndexInformer
+	// retryPeriod is how often adding of discovered namespaces that failed to be added is retried.
+	retryPeriod time.Duration
+
+	mu        sync.RWMutex
+	informers map[string]*namespaceInformer
+	handlers  []handlerWithResync
+	indexers  cache.Indexers
+	// stopCh is set once the informer has been started.
+	stopCh <-chan struct{}
+}
+
+const namespaceRetryPeriod = 5 * time.Second
+
+type namespaceInformer struct {
+	informer cache.SharedIndexInformer
+	stop     chan struct{}
+}
+
+type handlerWithResync struct {
+	handler      cache.ResourceEventHandler
+	resyncPeriod time.Duration
+}
+
+// NewMultiNamespaceInformer constructs an informer that watches objects in all of the namespaces.
+// If namespaceSelector is not empty, namespaces that match the label selector are watched too.
+func NewMultiNamespaceInformer(logger *zap.Logger, client kubernetes.Interface, namespaces []string, namespaceSelector string, resyncPeriod time.Duration, newInformer func(namespace string) cache.SharedIndexInformer) cache.SharedIndexInformer {
+	m := &multiNamespaceInformer{
+		logger:      logger,
+		newInformer: newInformer,
+		retryPeriod: namespaceRetryPeriod,
+		informers:   make(map[string]*namespaceInformer),
+		indexers:    cache.Indexers{},
+	}
+	for _, namespace := range namespaces {
+		m.informers[namespace] = &namespaceInformer{
+			informer: newInformer(namespace),
+			stop:     make(chan struct{}),
+		}
+	}
+	if namespaceSelector != "" {
+		m.namespaceInformer = core_v1inf.NewFilteredNamespaceInformer(client, resyncPeriod, cache.Indexers{}, func(options *meta_v1.ListOptions) {
+			options.LabelSelector = namespaceSelector
+		})
+		m.namespaceInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc: func(obj interface{}) {
+				namespace := obj.(*core_v1.Namespace).Name
+				if err := m.addNamespace(namespace); err != nil {
+					// Adding is retried by addDiscoveredNamespaces
+					m.logger.Error("Failed to add namespace to informer, will retry", zap.String("namespace", namespace), zap.Error(err))
+				}
+			},
+			DeleteFunc: func(obj interface{}) {
+				if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+					obj = tombstone.Obj
+				}
+				if ns, ok := obj.(*core_v1.Namespace); ok {
+					m.removeNamespace(ns.Name)
+				}
+			},
+		})
+	}
+	return m
+}
+
+func (m *multiNamespaceInformer) addNamespace(namespace string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.informers[namespace]; ok {
+		return nil
+	}
+	nsInf := &namespaceInformer{
+		informer: m.newInformer(namespace),
+		stop:     make(chan struct{}),
+	}
+	// Indexers can only be added before the informer is started
+	if err := nsInf.informer.AddIndexers(m.indexers); err != nil {
+		return errors.Wrapf(err, "failed to add indexers to informer for namespace %s", namespace)
+	}
+	for _, h := range m.handlers {
+		nsInf.informer.AddEventHandlerWithResyncPeriod(h.handler, h.resyncPeriod)
+	}
+	m.informers[namespace] = nsInf
+	if m.stopCh != nil {
+		m.runNamespaceInformer(nsInf)
+	}
+	return nil
+}
+
+// addDiscoveredNamespaces adds namespaces that have been discovered but failed to be added.
+func (m *multiNamespaceInformer) addDiscoveredNamespaces() {
+	for _, namespace := range m.namespaceInformer.GetStore().ListKeys() {
+		if err := m.addNamespace(namespace); err != nil {
+			m.logger.Error("Failed to add namespace to informer, will retry", zap.String("namespace", namespace), zap.Error(err))
+		}
+	}
+}
+
+func (m *multiNamespaceInformer) removeNamespace(namespace string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	nsInf, ok := m.informers[namespace]
+	if !ok {
+		return
+	}
+	delete(m.informers, namespace)
+	close(nsInf.stop)
+}
+
+// runNamespaceInformer runs the informer until it is stopped or the multi namespace informer is stopped.
+// Must be called with the mutex held.
+func (m *multiNamespaceInformer) runNamespaceInformer(nsInf *namespaceInformer) {
+	go nsInf.informer.Run(mergeStopChannels(m.stopCh, nsInf.stop))
+}
+
+func (m *multiNamespaceInformer) AddEventHandler(handler cache.ResourceEventHandler) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.handlers = append(m.handlers, handlerWithResync{handler: handler})
+	for _, nsInf := range m.informers {
+		nsInf.informer.AddEventHandler(handler)
+	}
+}
+
+func (m *multiNamespaceInformer) AddEventHandlerWithResyncPeriod(handler cache.ResourceEventHandler, resyncPeriod time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.handlers = append(m.handlers, handlerWithResync{handler: handler, resyncPeriod: resyncPeriod})
+	for _, nsInf := range m.informers {
+		nsInf.informer.AddEventHandlerWithResyncPeriod(handler, resyncPeriod)
+	}
+}
+
+func (m *multiNamespaceInformer) GetStore() cache.Store {
+	return m.GetIndexer()
+}
+
+func (m *multiNamespaceInformer) GetController() cache.Controller {
+	return m
+}
+
+func (m *multiNamespaceInformer) Run(stopCh <-chan struct{}) {
+	m.mu.Lock()
+	m.stopCh = stopCh
+	for _, nsInf := range m.informers {
+		m.runNamespaceInformer(nsInf)
+	}
+	m.mu.Unlock()
+	if m.namespaceInformer == nil {
+		<-stopCh
+		return
+	}
+	go m.namespaceInformer.Run(stopCh)
+	if !cache.WaitForCacheSync(stopCh, m.namespaceInformer.HasSynced) {
+		return
+	}
+	wait.Until(m.addDiscoveredNamespaces, m.retryPeriod, stopCh)
+}
+
+// HasSynced returns true once all discovered namespaces have been added and informers for all namespaces
+// have synced.
+func (m *multiNamespaceInformer) HasSynced() bool {
+	if m.namespaceInformer != nil && !m.namespaceInformer.HasSynced() {
+		return false
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.namespaceInformer != nil {
+		// Namespaces are added asynchronously by the event handler
+		for _, namespace := range m.namespaceInformer.GetStore().ListKeys() {
+			if _, ok := m.informers[namespace]; !ok {
+				return false
+			}
+		}
+	}
+	for _, nsInf := range m.informers {
+		if !nsInf.informer.HasSynced() {
+			return false
+		}
+	}
+	return true
+}
+
+// LastSyncResourceVersion returns an empty string because resource versions of different watches
+// cannot be merged into one.
+func (m *multiNamespaceInformer) LastSyncResourceVersion() string {
+	return ""
+}
+
+func (m *multiNamespaceInformer) AddIndexers(indexers cache.Indexers) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.stopCh != nil {
+		return errors.New("informer has already started")
+	}
+	for name, indexFunc := range indexers {
+		if _, ok := m.indexers[name]; ok {
+			return errors.Errorf("indexer conflict: %s", name)
+		}
+		m.indexers[name] = indexFunc
+	}
+	for _, nsInf := range m.informers {
+		if err := nsInf.informer.AddIndexers(indexers); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *multiNamespaceInformer) GetIndexer() cache.Indexer {
+	return &multiNamespaceIndexer{
+		informer: m,
+	}
+}
+
+// namespaceIndexers returns indexers of all watched namespaces.
+func (m *multiNamespaceInformer) namespaceIndexers() []cache.Indexer {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	indexers := make([]cache.Indexer, 0, len(m.informers))
+	for _, nsInf := range m.informers {
+		indexers = append(indexers, nsInf.informer.GetIndexer())
+	}
+	return indexers
+}
+
+func (m *multiNamespaceInformer) namespaceIndexer(namespace string) (cache.Indexer, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	nsInf, ok := m.informers[namespace]
+	if !ok {
+		return nil, false
+	}
+	return nsInf.informer.GetIndexer(), true
+}
+
+// multiNamespaceIndexer is a read only view of indexers of all namespaces of a multiNamespaceInformer.
+type multiNamespaceIndexer struct {
+	informer *multiNamespaceInformer
+}
+
+func (i *multiNamespaceIndexer) Add(obj interface{}) error {
+	return errors.New("multi namespace indexer is read only")
+}
+
+func (i *multiNamespaceIndexer) Update(obj interface{}) error {
+	return errors.New("multi namespace indexer is read only")
+}
+
+func (i *multiNamespaceIndexer) Delete(obj interface{}) error {
+	return errors.New("multi namespace indexer is read only")
+}
+
+func (i *multiNamespaceIndexer) Replace([]interface{}, string) error {
+	return errors.New("multi namespace indexer is read only")
+}
+
+func (i *multiNamespaceIndexer) Resync() error {
+	return errors.New("multi namespace indexer is read only")
+}
+
+func (i *multiNamespaceIndexer) List() []interface{} {
+	var list []interface{}
+	for _, indexer := range i.informer.namespaceIndexers() {
+		list = append(list, indexer.List()...)
+	}
+	return list
+}
+
+func (i *multiNamespaceIndexer) ListKeys() []string {
+	var keys []string
+	for _, indexer := range i.informer.namespaceIndexers() {
+		keys = append(keys, indexer.ListKeys()...)
+	}
+	return keys
+}
+
+func (i *multiNamespaceIndexer) Get(obj interface{}) (interface{}, bool, error) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		return nil, false, cache.KeyError{Obj: obj, Err: err}
+	}
+	return i.GetByKey(key)
+}
+
+func (i *multiNamespaceIndexer) GetByKey(key string) (interface{}, bool, error) {
+	namespace, _, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return nil, false, err
+	}
+	indexer, ok := i.informer.namespaceIndexer(namespace)
+	if !ok {
+		return nil, false, nil
+	}
+	return indexer.GetByKey(key)
+}
+
+func (i *multiNamespaceIndexer) Index(indexName string, obj interface{}) ([]interface{}, error) {
+	var list []interface{}
+	for _, indexer := range i.informer.namespaceIndexers() {
+		objs, err := indexer.Index(indexName, obj)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, objs...)
+	}
+	return list, nil
+}
+
+func (i *multiNamespaceIndexer) IndexKeys(indexName, indexedValue string) ([]string, error) {
+	var keys []string
+	for _, indexer := range i.informer.namespaceIndexers() {
+		k, err := indexer.IndexKeys(indexName, indexedValue)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k...)
+	}
+	return keys, nil
+}
+
+func (i *multiNamespaceIndexer) ListIndexFuncValues(indexName string) []string {
+	seen := make(map[string]struct{})
+	var values []string
+	for _, indexer := range i.informer.namespaceIndexers() {
+		for _, value := range indexer.ListIndexFuncValues(indexName) {
+			if _, ok := seen[value]; ok {
+				continue
+			}
+			seen[value] = struct{}{}
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+func (i *multiNamespaceIndexer) ByIndex(indexName, indexedValue string) ([]interface{}, error) {
+	var list []interface{}
+	for _, indexer := range i.informer.namespaceIndexers() {
+		objs, err := indexer.ByIndex(indexName, indexedValue)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, objs...)
+	}
+	return list, nil
+}
+
+func (i *multiNamespaceIndexer) GetIndexers() cache.Indexers {
+	i.informer.mu.RLock()
+	defer i.informer.mu.RUnlock()
+	indexers := make(cache.Indexers, len(i.informer.indexers))
+	for name, indexFunc := range i.informer.indexers {
+		indexers[name] = indexFunc
+	}
+	return indexers
+}
+
+func (i *multiNamespaceIndexer) AddIndexers(indexers cache.Indexers) error {
+	return i.informer.AddIndexers(indexers)
+}
+
+// mergeStopChannels returns a channel that is closed once any of the channels is closed.
+func mergeStopChannels(a, b <-chan struct{}) <-chan struct{} {
+	merged := make(chan struct{})
+	go func() {
+		defer close(merged)
+		select {
+		case <-a:
+		case <-b:
+		}
+	}()
+	return merged
+}
@@ -0,0 +1,99 @@
+package ctrl
+
+import (
+	"sort"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+	core_v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	core_v1inf "k8s.io/client-go/informers/core/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestMultiNamespaceInformer(t *testing.T) {
+	t.Parallel()
+
+	client := fake.NewSimpleClientset(
+		&core_v1.Namespace{ObjectMeta: meta_v1.ObjectMeta{Name: "ns1"}},
+		&core_v1.Namespace{ObjectMeta: meta_v1.ObjectMeta{Name: "ns2", Labels: map[string]string{"team": "a"}}},
+		&core_v1.Namespace{ObjectMeta: meta_v1.ObjectMeta{Name: "ns3"}},
+		&core_v1.ConfigMap{ObjectMeta: meta_v1.ObjectMeta{Namespace: "ns1", Name: "cm1"}},
+		&core_v1.ConfigMap{ObjectMeta: meta_v1.ObjectMeta{Namespace: "ns2", Name: "cm2"}},
+		&core_v1.ConfigMap{ObjectMeta: meta_v1.ObjectMeta{Namespace: "ns3", Name: "cm3"}},
+		&core_v1.ConfigMap{ObjectMeta: meta_v1.ObjectMeta{Namespace: "ns4", Name: "cm4"}},
+	)
+	inf := NewMultiNamespaceInformer(zaptest.NewLogger(t), client, []string{"ns1"}, "team=a", time.Hour, func(namespace string) cache.SharedIndexInformer {
+		return core_v1inf.NewConfigMapInformer(client, namespace, time.Hour, cache.Indexers{})
+	})
+	added := make(chan string, 10)
+	inf.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			added <- obj.(*core_v1.ConfigMap).Name
+		},
+	})
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	go inf.Run(stopCh)
+	// Informer has synced once discovered namespaces have been added and have synced
+	require.True(t, cache.WaitForCacheSync(stopCh, inf.HasSynced))
+
+	keys := inf.GetStore().ListKeys()
+	sort.Strings(keys)
+	assert.Equal(t, []string{"ns1/cm1", "ns2/cm2"}, keys)
+	_, exists, err := inf.GetIndexer().GetByKey("ns2/cm2")
+	require.NoError(t, err)
+	assert.True(t, exists)
+	_, exists, err = inf.GetIndexer().GetByKey("ns3/cm3")
+	require.NoError(t, err)
+	assert.False(t, exists)
+	assert.Error(t, inf.GetStore().Add(&core_v1.ConfigMap{}))
+
+	// New namespaces that match the selector are discovered
+	_, err = client.CoreV1().Namespaces().Create(&core_v1.Namespace{ObjectMeta: meta_v1.ObjectMeta{Name: "ns4", Labels: map[string]string{"team": "a"}}})
+	require.NoError(t, err)
+	names := map[string]bool{}
+	for len(names) < 3 {
+		select {
+		case name := <-added:
+			names[name] = true
+		case <-time.After(10 * time.Second):
+			t.Fatalf("timed out waiting for objects to be added, got %v", names)
+		}
+	}
+	assert.Equal(t, map[string]bool{"cm1": true, "cm2": true, "cm4": true}, names)
+}
+
+func TestMultiNamespaceInformerRetriesAddingNamespace(t *testing.T) {
+	t.Parallel()
+
+	client := fake.NewSimpleClientset(
+		&core_v1.Namespace{ObjectMeta: meta_v1.ObjectMeta{Name: "ns1", Labels: map[string]string{"team": "a"}}},
+		&core_v1.ConfigMap{ObjectMeta: meta_v1.ObjectMeta{Namespace: "ns1", Name: "cm1"}},
+	)
+	var attempts int32
+	inf := NewMultiNamespaceInformer(zaptest.NewLogger(t), client, nil, "team=a", time.Hour, func(namespace string) cache.SharedIndexInformer {
+		indexers := cache.Indexers{}
+		if atomic.AddInt32(&attempts, 1) == 1 {
+			// Conflicts with the indexer of the multi namespace informer
+			indexers["byName"] = cache.MetaNamespaceIndexFunc
+		}
+		return core_v1inf.NewConfigMapInformer(client, namespace, time.Hour, indexers)
+	})
+	inf.(*multiNamespaceInformer).retryPeriod = 10 * time.Millisecond
+	require.NoError(t, inf.AddIndexers(cache.Indexers{"byName": cache.MetaNamespaceIndexFunc}))
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	go inf.Run(stopCh)
+	require.True(t, cache.WaitForCacheSync(stopCh, inf.HasSynced))
+
+	assert.Equal(t, []string{"ns1/cm1"}, inf.GetStore().ListKeys())
+	assert.True(t, atomic.LoadInt32(&attempts) > 1)
+}
@@ -0,0 +1,145 @@
+package options
+
+import (
+	"strings"
+
+	"github.com/atlassian/ctrl"
+	"github.com/pkg/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/transport"
+	"k8s.io/client-go/util/flowcontrol"
+)
+
+const (
+	// ClusterSecretKubeconfigKey is the key in a cluster Secret's data that holds the kubeconfig of the cluster.
+	ClusterSecretKubeconfigKey = "kubeconfig"
+)
+
+// ClusterOptions configure additional clusters that are watched by multi-cluster controllers.
+// Clusters are loaded once on startup.
+type ClusterOptions struct {
+	// ClusterContexts are contexts of additional clusters in the REST client configuration file.
+	// Context name is used as the cluster name. Parsed from a comma separated list.
+	ClusterContexts []string
+	// ClusterSecretsNamespace is the namespace of Secrets with kubeconfigs of additional clusters.
+	// Secret name is used as the cluster name.
+	ClusterSecretsNamespace string
+	// ClusterSecretSelector is a label selector for Secrets with kubeconfigs of additional clusters.
+	ClusterSecretSelector string
+
+	clusterContexts string
+}
+
+func (o *ClusterOptions) DefaultAndValidate() []error {
+	var allErrors []error
+	if o.clusterContexts != "" {
+		o.ClusterContexts = nil
+		for _, context := range strings.Split(o.clusterContexts, ",") {
+			context = strings.TrimSpace(context)
+			if context == "" {
+				allErrors = append(allErrors, errors.Errorf("cluster contexts list must not contain empty context names. Given: %q", o.clusterContexts))
+				continue
+			}
+			o.ClusterContexts = append(o.ClusterContexts, context)
+		}
+	}
+	if o.ClusterSecretSelector != "" {
+		if o.ClusterSecretsNamespace == "" {
+			allErrors = append(allErrors, errors.New("cluster secret selector requires cluster secrets namespace"))
+		}
+		if _, err := labels.Parse(o.ClusterSecretSelector); err != nil {
+			allErrors = append(allErrors, errors.Wrap(err, "invalid cluster secret selector"))
+		}
+	}
+	return allErrors
+}
+
+func BindClusterFlags(o *ClusterOptions, fs ctrl.FlagSet) {
+	fs.StringVar(&o.clusterContexts, "cluster-kubeconfig-contexts", "", ""+
+		"Comma separated list of contexts of additional clusters in the file specified by --client-config-file-name. "+
+		"Context names are used as cluster names. Additional clusters are only watched by multi-cluster controllers")
+	fs.StringVar(&o.ClusterSecretsNamespace, "cluster-secrets-namespace", "", ""+
+		"Namespace of Secrets with kubeconfigs of additional clusters in the '"+ClusterSecretKubeconfigKey+"' key. "+
+		"Secret names are used as cluster names. Additional clusters are only watched by multi-cluster controllers")
+	fs.StringVar(&o.ClusterSecretSelector, "cluster-secret-selector", "",
+		"Label selector for Secrets with kubeconfigs of additional clusters. This is only applicable if --cluster-secrets-namespace is set")
+}
+
+// LoadClusters loads additional clusters from the kubeconfig contexts and Secrets. Secrets are read
+// using the main cluster's client. wrapTransport is applied to the REST configurations of clusters. Optional.
+func LoadClusters(userAgent string, restOptions RestClientOptions, options ClusterOptions, mainClient kubernetes.Interface, wrapTransport transport.WrapperFunc) ([]ctrl.Cluster, error) {
+	var clusters []ctrl.Cluster
+	if len(options.ClusterContexts) > 0 {
+		if restOptions.ClientConfigFileName == "" {
+			return nil, errors.New("cluster contexts require a REST client configuration file")
+		}
+		configAPI, err := clientcmd.LoadFromFile(restOptions.ClientConfigFileName)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to load REST client configuration from file %q", restOptions.ClientConfigFileName)
+		}
+		for _, context := range options.ClusterContexts {
+			config, err := clientcmd.NewDefaultClientConfig(*configAPI, &clientcmd.ConfigOverrides{
+				CurrentContext: context,
+			}).ClientConfig()
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to load REST client configuration for context %q", context)
+			}
+			cluster, err := newCluster(context, config, userAgent, restOptions, wrapTransport)
+			if err != nil {
+				return nil, err
+			}
+			clusters = append(clusters, cluster)
+		}
+	}
+	if options.ClusterSecretsNamespace != "" {
+		secrets, err := mainClient.CoreV1().Secrets(options.ClusterSecretsNamespace).List(meta_v1.ListOptions{
+			LabelSelector: options.ClusterSecretSelector,
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to list cluster secrets")
+		}
+		for _, secret := range secrets.Items {
+			kubeconfig, ok := secret.Data[ClusterSecretKubeconfigKey]
+			if !ok {
+				return nil, errors.Errorf("cluster secret %s/%s does not have the %q key", secret.Namespace, secret.Name, ClusterSecretKubeconfigKey)
+			}
+			config, err := clientcmd.RESTConfigFromKubeConfig(kubeconfig)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to load REST client configuration from cluster secret %s/%s", secret.Namespace, secret.Name)
+			}
+			cluster, err := newCluster(secret.Name, config, userAgent, restOptions, wrapTransport)
+			if err != nil {
+				return nil, err
+			}
+			clusters = append(clusters, cluster)
+		}
+	}
+	return clusters, nil
+}
+
+func newCluster(name string, config *rest.Config, userAgent string, restOptions RestClientOptions, wrapTransport transport.WrapperFunc) (ctrl.Cluster, error) {
+	config.RateLimiter = flowcontrol.NewTokenBucketRateLimiter(float32(restOptions.APIQPS), int(restOptions.APIQPS*APIQPSBurstFactor))
+	config.UserAgent = userAgent
+	if wrapTransport != nil {
+		config.WrapTransport = transport.Wrappers(config.WrapTransport, wrapTransport)
+	}
+	mainClient, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return ctrl.Cluster{}, errors.Wrapf(err, "failed to construct client for cluster %q", name)
+	}
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return ctrl.Cluster{}, errors.Wrapf(err, "failed to construct dynamic client for cluster %q", name)
+	}
+	return ctrl.Cluster{
+		Name:          name,
+		RestConfig:    config,
+		MainClient:    mainClient,
+		DynamicClient: dynamicClient,
+	}, nil
+}
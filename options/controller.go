@@ -1,20 +1,76 @@
 package options
 
 import (
+	"flag"
+	"strings"
 	"time"
 
 	"github.com/atlassian/ctrl"
+	"github.com/pkg/errors"
 	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 )
 
 const (
 	DefaultResyncPeriod = 20 * time.Minute
 	DefaultWorkers      = 2
+	DefaultSlowObjects  = 10
 )
 
 type GenericControllerOptions struct {
 	ResyncPeriod time.Duration
 	Workers      uint
+	// RetryPolicy overrides retry policies of controllers. Only fields of flags that have been set are applied.
+	RetryPolicy ctrl.RetryPolicyOverride
+	// ProcessTimeout is the default maximum duration of processing of a single object.
+	ProcessTimeout time.Duration
+	RecoverPanics  bool
+	EmitDropEvents bool
+	// LivenessStuckThreshold is the duration after which a worker processing a single object is considered stuck.
+	LivenessStuckThreshold time.Duration
+	// LivenessInformerEventTimeout is the maximum duration since an informer last observed a watch event.
+	LivenessInformerEventTimeout time.Duration
+	// ObjectMetricsCardinality controls how objects are identified in per-object metrics.
+	ObjectMetricsCardinality ctrl.MetricsCardinality
+	// SlowObjects is the number of objects that took longest to process to track. Disabled if zero.
+	SlowObjects int
+	// InformerSelector restricts objects that are watched by main informers.
+	InformerSelector ctrl.Selector
+	// StripManagedFields removes managed fields from objects before they are cached by informers that support transforms.
+	StripManagedFields bool
+	// StripAnnotations is a list of annotations that are removed from objects before they are cached
+	// by informers that support transforms. Parsed from a comma separated list.
+	StripAnnotations []string
+
+	stripAnnotations string
+	// Retry flags. Only flags that have been set override retry policies of controllers.
+	maxRetries     int
+	retryForever   bool
+	retryBaseDelay time.Duration
+	retryMaxDelay  time.Duration
+	retryJitter    float64
+	// flagSet is used to find out which retry flags have been set. If the flag set the flags were bound to
+	// cannot be visited, flags with non-zero values are considered set.
+	flagSet visitor
+}
+
+// visitor is implemented by *flag.FlagSet.
+type visitor interface {
+	// Visit visits flags that have been set.
+	Visit(fn func(*flag.Flag))
+}
+
+// InformerTransform returns the transform for informers that support transforms. Returns nil if objects
+// should not be transformed.
+func (o *GenericControllerOptions) InformerTransform() ctrl.TransformFunc {
+	var transforms []ctrl.TransformFunc
+	if o.StripManagedFields {
+		transforms = append(transforms, ctrl.StripManagedFields)
+	}
+	if len(o.StripAnnotations) > 0 {
+		transforms = append(transforms, ctrl.StripAnnotations(o.StripAnnotations...))
+	}
+	return ctrl.ChainTransforms(transforms...)
 }
 
 func (o *GenericControllerOptions) DefaultAndValidate() []error {
@@ -25,20 +81,197 @@ func (o *GenericControllerOptions) DefaultAndValidate() []error {
 	if o.Workers == 0 {
 		o.Workers = DefaultWorkers
 	}
+	allErrors = append(allErrors, o.defaultAndValidateRetryPolicy()...)
+	if o.ProcessTimeout < 0 {
+		allErrors = append(allErrors, errors.Errorf("value for process timeout must be non-negative. Given: %s", o.ProcessTimeout))
+	}
+	if o.LivenessStuckThreshold < 0 {
+		allErrors = append(allErrors, errors.Errorf("value for liveness stuck threshold must be non-negative. Given: %s", o.LivenessStuckThreshold))
+	}
+	if o.LivenessInformerEventTimeout < 0 {
+		allErrors = append(allErrors, errors.Errorf("value for liveness informer event timeout must be non-negative. Given: %s", o.LivenessInformerEventTimeout))
+	}
+	if o.ObjectMetricsCardinality == "" {
+		o.ObjectMetricsCardinality = ctrl.MetricsCardinalityGVK
+	}
+	switch o.ObjectMetricsCardinality {
+	case ctrl.MetricsCardinalityObject, ctrl.MetricsCardinalityNamespace, ctrl.MetricsCardinalityGVK:
+	default:
+		allErrors = append(allErrors, errors.Errorf("invalid object metrics cardinality %q", o.ObjectMetricsCardinality))
+	}
+	if o.SlowObjects < 0 {
+		allErrors = append(allErrors, errors.Errorf("number of slow objects to track must be non-negative. Given: %d", o.SlowObjects))
+	}
+	if err := o.InformerSelector.Validate(); err != nil {
+		allErrors = append(allErrors, err)
+	}
+	if o.stripAnnotations != "" {
+		o.StripAnnotations = nil
+		for _, key := range strings.Split(o.stripAnnotations, ",") {
+			key = strings.TrimSpace(key)
+			if key == "" {
+				allErrors = append(allErrors, errors.Errorf("list of annotations to strip must not contain empty keys. Given: %q", o.stripAnnotations))
+				continue
+			}
+			o.StripAnnotations = append(o.StripAnnotations, key)
+		}
+	}
+	return allErrors
+}
+
+// defaultAndValidateRetryPolicy sets fields of RetryPolicy from retry flags that have been set.
+func (o *GenericControllerOptions) defaultAndValidateRetryPolicy() []error {
+	var allErrors []error
+	set := o.setRetryFlags()
+	if set[maxRetriesFlag] {
+		if o.maxRetries < 0 {
+			allErrors = append(allErrors, errors.Errorf("value for max retries must be non-negative. Given: %d", o.maxRetries))
+		} else {
+			maxRetries := o.maxRetries
+			if maxRetries == 0 {
+				maxRetries = ctrl.NoRetries
+			}
+			o.RetryPolicy.MaxRetries = &maxRetries
+		}
+	}
+	if set[retryForeverFlag] {
+		retryForever := o.retryForever
+		o.RetryPolicy.RetryForever = &retryForever
+	}
+	if set[retryBaseDelayFlag] {
+		if o.retryBaseDelay < 0 {
+			allErrors = append(allErrors, errors.Errorf("value for retry base delay must be non-negative. Given: %s", o.retryBaseDelay))
+		} else {
+			baseDelay := o.retryBaseDelay
+			o.RetryPolicy.BaseDelay = &baseDelay
+		}
+	}
+	if set[retryMaxDelayFlag] {
+		if o.retryMaxDelay < 0 {
+			allErrors = append(allErrors, errors.Errorf("value for retry max delay must be non-negative. Given: %s", o.retryMaxDelay))
+		} else {
+			maxDelay := o.retryMaxDelay
+			o.RetryPolicy.MaxDelay = &maxDelay
+		}
+	}
+	if set[retryJitterFlag] {
+		if o.retryJitter < 0 {
+			allErrors = append(allErrors, errors.Errorf("value for retry jitter must be non-negative. Given: %g", o.retryJitter))
+		} else {
+			jitter := o.retryJitter
+			o.RetryPolicy.Jitter = &jitter
+		}
+	}
 	return allErrors
 }
 
+// setRetryFlags returns the names of retry flags that have been set.
+func (o *GenericControllerOptions) setRetryFlags() map[string]bool {
+	set := make(map[string]bool)
+	if o.flagSet != nil {
+		o.flagSet.Visit(func(f *flag.Flag) {
+			set[f.Name] = true
+		})
+		return set
+	}
+	set[maxRetriesFlag] = o.maxRetries != 0
+	set[retryForeverFlag] = o.retryForever
+	set[retryBaseDelayFlag] = o.retryBaseDelay != 0
+	set[retryMaxDelayFlag] = o.retryMaxDelay != 0
+	set[retryJitterFlag] = o.retryJitter != 0
+	return set
+}
+
+const (
+	maxRetriesFlag     = "max-retries"
+	retryForeverFlag   = "retry-forever"
+	retryBaseDelayFlag = "retry-base-delay"
+	retryMaxDelayFlag  = "retry-max-delay"
+	retryJitterFlag    = "retry-jitter"
+)
+
 func BindGenericControllerFlags(o *GenericControllerOptions, fs ctrl.FlagSet) {
 	fs.DurationVar(&o.ResyncPeriod, "resync-period", DefaultResyncPeriod, "Resync period for informers")
-	fs.UintVar(&o.Workers, "workers", DefaultWorkers, "Number of workers that handle events from informers")
+	fs.UintVar(&o.Workers, "workers", DefaultWorkers, "Number of workers that handle events from informers. Used for each controller that does not specify its own number of workers")
+	if v, ok := fs.(visitor); ok {
+		o.flagSet = v
+	}
+	fs.IntVar(&o.maxRetries, maxRetriesFlag, 0, ""+
+		"Number of times an object is retried before it is dropped out of the queue. 0 disables retries. "+
+		"Overrides retry policies of controllers if set")
+	fs.BoolVar(&o.retryForever, retryForeverFlag, false, ""+
+		"Retry objects until they are processed successfully if true, use --max-retries if false. "+
+		"Overrides retry policies of controllers if set")
+	fs.DurationVar(&o.retryBaseDelay, retryBaseDelayFlag, 0, ""+
+		"Delay before the first retry, doubled with each subsequent retry. 0 uses the default delay. "+
+		"Overrides retry policies of controllers if set")
+	fs.DurationVar(&o.retryMaxDelay, retryMaxDelayFlag, 0, ""+
+		"Maximum delay between retries. 0 uses the default delay. Overrides retry policies of controllers if set")
+	fs.DurationVar(&o.ProcessTimeout, "process-timeout", 0, "Maximum duration of processing of a single object. Used for each controller that does not specify its own timeout. No timeout if zero")
+	fs.BoolVar(&o.RecoverPanics, "recover-panics", false, "Recover panics that happen while processing an object and retry the object instead of crashing")
+	fs.BoolVar(&o.EmitDropEvents, "emit-drop-events", false, "Emit a Warning event for objects that are dropped out of the work queue because of an error")
+	fs.DurationVar(&o.LivenessStuckThreshold, "liveness-stuck-threshold", 0, ""+
+		"Duration after which a worker that is processing a single object is considered stuck and the controller is "+
+		"reported as not live. Disabled if zero")
+	fs.DurationVar(&o.LivenessInformerEventTimeout, "liveness-informer-event-timeout", 0, ""+
+		"Maximum duration since an informer of a controller last observed a watch event, a bookmark or a relist after "+
+		"which the controller is reported as not live. Resync events are not counted. Must be longer than the expected "+
+		"interval between changes of watched objects. Disabled if zero")
+	fs.StringVar((*string)(&o.ObjectMetricsCardinality), "object-metrics-cardinality", string(ctrl.MetricsCardinalityGVK), ""+
+		"How objects are identified in per-object metrics. 'object' labels metrics with object namespace and name, "+
+		"'namespace' labels metrics with object namespace only, 'gvk' aggregates metrics per controller. "+
+		"'object' produces a series per object and should only be used with a small number of objects")
+	fs.IntVar(&o.SlowObjects, "slow-objects", DefaultSlowObjects,
+		"Number of objects that took longest to process to list on the auxiliary server /slowobjects endpoint. Disabled if zero")
+	fs.StringVar(&o.InformerSelector.LabelSelector, "informer-label-selector", "", "Label selector that restricts objects watched and cached by informers of controllers e.g. 'app=foo'. Controllers with informers that do not support selectors fail to start if set")
+	fs.StringVar(&o.InformerSelector.FieldSelector, "informer-field-selector", "", "Field selector that restricts objects watched and cached by informers of controllers e.g. 'metadata.namespace!=kube-system'. Controllers with informers that do not support selectors fail to start if set")
+	fs.BoolVar(&o.StripManagedFields, "informer-strip-managed-fields", false, "Remove managed fields from objects before they are cached by informers that support transforms")
+	fs.StringVar(&o.stripAnnotations, "informer-strip-annotations", "", ""+
+		"Comma separated list of annotations to remove from objects before they are cached by informers that support transforms "+
+		"e.g. 'kubectl.kubernetes.io/last-applied-configuration'")
+	fs.Float64Var(&o.retryJitter, retryJitterFlag, 0, "Jitter factor for delays between retries. 0 disables jitter. Overrides retry policies of controllers if set")
 }
 
 type GenericNamespacedControllerOptions struct {
 	GenericControllerOptions
 	Namespace string
+	// Namespaces is a list of namespaces to use. Parsed from a comma separated list.
+	Namespaces []string
+	// NamespaceSelector is a label selector for namespaces to use in addition to Namespaces.
+	NamespaceSelector string
+
+	namespaces string
+}
+
+func (o *GenericNamespacedControllerOptions) DefaultAndValidate() []error {
+	allErrors := o.GenericControllerOptions.DefaultAndValidate()
+	if o.namespaces != "" {
+		o.Namespaces = nil
+		for _, namespace := range strings.Split(o.namespaces, ",") {
+			namespace = strings.TrimSpace(namespace)
+			if namespace == "" {
+				allErrors = append(allErrors, errors.Errorf("namespaces list must not contain empty namespace names. Given: %q", o.namespaces))
+				continue
+			}
+			o.Namespaces = append(o.Namespaces, namespace)
+		}
+	}
+	if o.Namespace != meta_v1.NamespaceAll && (len(o.Namespaces) > 0 || o.NamespaceSelector != "") {
+		allErrors = append(allErrors, errors.New("namespace cannot be used together with a list of namespaces or a namespace selector"))
+	}
+	if o.NamespaceSelector != "" {
+		if _, err := labels.Parse(o.NamespaceSelector); err != nil {
+			allErrors = append(allErrors, errors.Wrap(err, "invalid namespace selector"))
+		}
+	}
+	return allErrors
 }
 
 func BindGenericNamespacedControllerFlags(o *GenericNamespacedControllerOptions, fs ctrl.FlagSet) {
 	BindGenericControllerFlags(&o.GenericControllerOptions, fs)
 	fs.StringVar(&o.Namespace, "namespace", meta_v1.NamespaceAll, "Namespace to use. All namespaces are used if empty string or omitted")
+	fs.StringVar(&o.namespaces, "namespaces", "", "Comma separated list of namespaces to use. Cannot be used together with --namespace")
+	fs.StringVar(&o.NamespaceSelector, "namespace-selector", "", ""+
+		"Label selector for namespaces to use in addition to --namespaces. Namespaces are discovered and "+
+		"watched as they appear. Cannot be used together with --namespace")
 }
@@ -0,0 +1,85 @@
+package options
+
+import (
+	"flag"
+	"testing"
+
+	"github.com/atlassian/ctrl"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryFlagsOverrideOnlyIfSet(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name         string
+		args         []string
+		maxRetries   *int
+		retryForever *bool
+		jitter       *float64
+	}{
+		{
+			name: "not set",
+		},
+		{
+			name:         "zero values",
+			args:         []string{"-max-retries=0", "-retry-forever=false", "-retry-jitter=0"},
+			maxRetries:   intPtr(ctrl.NoRetries),
+			retryForever: boolPtr(false),
+			jitter:       float64Ptr(0),
+		},
+		{
+			name:         "bool flag without value",
+			args:         []string{"-retry-forever"},
+			retryForever: boolPtr(true),
+		},
+		{
+			name:         "non-zero values",
+			args:         []string{"-max-retries=3", "-retry-forever=true", "-retry-jitter=0.5"},
+			maxRetries:   intPtr(3),
+			retryForever: boolPtr(true),
+			jitter:       float64Ptr(0.5),
+		},
+	}
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+
+			var o GenericControllerOptions
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			BindGenericControllerFlags(&o, fs)
+			require.NoError(t, fs.Parse(c.args))
+			require.Empty(t, o.DefaultAndValidate())
+			assert.Equal(t, c.maxRetries, o.RetryPolicy.MaxRetries)
+			assert.Equal(t, c.retryForever, o.RetryPolicy.RetryForever)
+			assert.Equal(t, c.jitter, o.RetryPolicy.Jitter)
+			assert.Nil(t, o.RetryPolicy.BaseDelay)
+			assert.Nil(t, o.RetryPolicy.MaxDelay)
+		})
+	}
+}
+
+func TestRetryFlagsValidation(t *testing.T) {
+	t.Parallel()
+
+	var o GenericControllerOptions
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	BindGenericControllerFlags(&o, fs)
+	require.NoError(t, fs.Parse([]string{"-max-retries=-1", "-retry-base-delay=-1s", "-retry-jitter=-0.5"}))
+	assert.Len(t, o.DefaultAndValidate(), 3)
+	assert.Error(t, fs.Parse([]string{"-retry-forever=maybe"}))
+}
+
+func intPtr(i int) *int {
+	return &i
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
+
+func float64Ptr(f float64) *float64 {
+	return &f
+}
@@ -3,13 +3,16 @@ package options
 import (
 	"context"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/atlassian/ctrl"
 	"github.com/atlassian/ctrl/logz"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
 	"go.uber.org/zap"
 	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	core_v1client "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/leaderelection"
 	"k8s.io/client-go/tools/leaderelection/resourcelock"
 	"k8s.io/client-go/tools/record"
@@ -19,46 +22,104 @@ const (
 	defaultLeaseDuration = 15 * time.Second
 	defaultRenewDeadline = 10 * time.Second
 	defaultRetryPeriod   = 2 * time.Second
+
+	metricsNamespace = "ctrl"
+
+	// ConfigMapsLeasesResourceLock is a lock type that holds the lock in both a ConfigMap and a Lease.
+	// Used to migrate from ConfigMap locks to Lease locks.
+	ConfigMapsLeasesResourceLock = "configmapsleases"
 )
 
 // See k8s.io/apiserver/pkg/apis/config/types.go LeaderElectionConfiguration
 // for leader election configuration description.
 type LeaderElectionOptions struct {
-	LeaderElect        bool
-	LeaseDuration      time.Duration
-	RenewDeadline      time.Duration
-	RetryPeriod        time.Duration
-	ConfigMapNamespace string
-	ConfigMapName      string
+	LeaderElect   bool
+	LeaseDuration time.Duration
+	RenewDeadline time.Duration
+	RetryPeriod   time.Duration
+	// ResourceLock is the type of the lock. One of "leases", "configmaps" or "configmapsleases".
+	ResourceLock      string
+	ResourceNamespace string
+	ResourceName      string
+	// Identity of this candidate. Hostname and component name are used if empty.
+	Identity string
+	// ReleaseOnCancel releases the lease when leader election is stopped.
+	ReleaseOnCancel bool
+	// WarmStandby makes replicas that are not leading start and sync informers so that a new leader
+	// can start processing without waiting for a full relist.
+	// Replicas always keep informers warm if any controller has a separate leader election lock.
+	WarmStandby bool
+	// StandbyRunControllers makes replicas that are not leading run controllers too. Only applicable with WarmStandby.
+	StandbyRunControllers bool
+}
+
+func (o *LeaderElectionOptions) DefaultAndValidate() []error {
+	var allErrors []error
+	if o.ResourceLock == "" {
+		o.ResourceLock = resourcelock.ConfigMapsResourceLock
+	}
+	switch o.ResourceLock {
+	case resourcelock.LeasesResourceLock, resourcelock.ConfigMapsResourceLock, ConfigMapsLeasesResourceLock:
+	default:
+		allErrors = append(allErrors, errors.Errorf("invalid leader election resource lock type %q", o.ResourceLock))
+	}
+	if o.StandbyRunControllers && !o.WarmStandby {
+		allErrors = append(allErrors, errors.New("running controllers on standby replicas requires warm standby"))
+	}
+	if o.LeaderElect && o.RenewDeadline > o.LeaseDuration {
+		allErrors = append(allErrors, errors.Errorf("leader election renew deadline %s must be less than or equal to the lease duration %s", o.RenewDeadline, o.LeaseDuration))
+	}
+	return allErrors
 }
 
 // DoLeaderElection starts leader election and blocks until it acquires the lease.
 // Returned context is cancelled once the lease is lost or ctx signals done.
-func DoLeaderElection(ctx context.Context, logger *zap.Logger, component string, config LeaderElectionOptions, configMapsGetter core_v1client.ConfigMapsGetter, recorder record.EventRecorder) (context.Context, error) {
-	id, err := os.Hostname()
+// Returned release function stops leader election and blocks until it has stopped. It must be called once
+// all work that requires leadership has stopped. The lease is released if ReleaseOnCancel is set so
+// that another candidate can acquire it without waiting for it to expire.
+// Metrics are registered with registry if it is not nil.
+func DoLeaderElection(ctx context.Context, logger *zap.Logger, component string, config LeaderElectionOptions, client kubernetes.Interface, recorder record.EventRecorder, registry prometheus.Registerer) (context.Context, func(), error) {
+	id := config.Identity
+	if id == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, nil, err
+		}
+		id = hostname + "-" + component
+	}
+	resourceLock, err := newResourceLock(config, client, resourcelock.ResourceLockConfig{
+		Identity:      id,
+		EventRecorder: recorder,
+	})
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	lock := &handoverLock{
+		Interface: resourceLock,
 	}
+	metrics, err := registerLeaderElectionMetrics(registry)
+	if err != nil {
+		return nil, nil, err
+	}
+	startTime := time.Now()
 	ctxRet, cancel := context.WithCancel(ctx)
 	startedLeading := make(chan struct{})
 	le, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
-		Lock: &resourcelock.ConfigMapLock{
-			ConfigMapMeta: meta_v1.ObjectMeta{
-				Namespace: config.ConfigMapNamespace,
-				Name:      config.ConfigMapName,
-			},
-			Client: configMapsGetter,
-			LockConfig: resourcelock.ResourceLockConfig{
-				Identity:      id + "-" + component,
-				EventRecorder: recorder,
-			},
-		},
-		LeaseDuration: config.LeaseDuration,
-		RenewDeadline: config.RenewDeadline,
-		RetryPeriod:   config.RetryPeriod,
+		Lock:            lock,
+		LeaseDuration:   config.LeaseDuration,
+		RenewDeadline:   config.RenewDeadline,
+		RetryPeriod:     config.RetryPeriod,
+		ReleaseOnCancel: config.ReleaseOnCancel,
 		Callbacks: leaderelection.LeaderCallbacks{
 			OnStartedLeading: func(ctx context.Context) {
-				logger.Info("Started leading")
+				waitTime := time.Since(startTime)
+				fields := []zap.Field{zap.Duration("acquire_time", waitTime)}
+				metrics.acquireTime.WithLabelValues(config.ResourceName).Observe(waitTime.Seconds())
+				if handover, ok := lock.takeHandover(); ok {
+					fields = append(fields, zap.Duration("handover_time", handover))
+					metrics.handoverTime.WithLabelValues(config.ResourceName).Observe(handover.Seconds())
+				}
+				logger.Info("Started leading", fields...)
 				close(startedLeading)
 			},
 			OnStoppedLeading: func() {
@@ -69,19 +130,175 @@ func DoLeaderElection(ctx context.Context, logger *zap.Logger, component string,
 	})
 	if err != nil {
 		cancel()
-		return nil, err
+		return nil, nil, err
 	}
+	// Leader election is not stopped when ctx is done. It is stopped by release so that the lease
+	// is not released before the caller stops all work.
+	leCtx, leCancel := context.WithCancel(context.Background())
+	leDone := make(chan struct{})
 	go func() {
+		defer close(leDone)
 		// note: because le.Run() also adds a logging panic handler panics with be logged 3 times
 		defer logz.LogStructuredPanic()
-		le.Run(ctx)
+		le.Run(leCtx)
 	}()
+	release := func() {
+		releaseStartTime := time.Now()
+		leCancel()
+		<-leDone
+		cancel()
+		if config.ReleaseOnCancel {
+			releaseTime := time.Since(releaseStartTime)
+			logger.Info("Released leadership", zap.Duration("release_time", releaseTime))
+			metrics.releaseTime.WithLabelValues(config.ResourceName).Observe(releaseTime.Seconds())
+		}
+	}
 	select {
 	case <-ctx.Done():
-		return nil, ctx.Err()
+		release()
+		return nil, nil, ctx.Err()
 	case <-startedLeading:
-		return ctxRet, nil
+		return ctxRet, release, nil
+	}
+}
+
+type leaderElectionMetrics struct {
+	acquireTime  *prometheus.HistogramVec
+	handoverTime *prometheus.HistogramVec
+	releaseTime  *prometheus.HistogramVec
+}
+
+func registerLeaderElectionMetrics(registry prometheus.Registerer) (leaderElectionMetrics, error) {
+	buckets := []float64{1, 2.5, 5, 10, 15, 20, 30, 45, 60, 120, 300, 600}
+	metrics := leaderElectionMetrics{
+		acquireTime: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace: metricsNamespace,
+				Name:      "leader_election_acquire_seconds",
+				Help:      "Time it took to acquire leadership since the leader election was started",
+				Buckets:   buckets,
+			},
+			[]string{"lock"},
+		),
+		handoverTime: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace: metricsNamespace,
+				Name:      "leader_election_handover_seconds",
+				Help:      "Time between the previous leader releasing the lease or the lease expiring and this replica acquiring it",
+				Buckets:   buckets,
+			},
+			[]string{"lock"},
+		),
+		releaseTime: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace: metricsNamespace,
+				Name:      "leader_election_release_seconds",
+				Help:      "Time it took to stop leader election and release the lease",
+				Buckets:   []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10},
+			},
+			[]string{"lock"},
+		),
+	}
+	if registry == nil {
+		return metrics, nil
+	}
+	for _, vec := range []**prometheus.HistogramVec{&metrics.acquireTime, &metrics.handoverTime, &metrics.releaseTime} {
+		if err := registry.Register(*vec); err != nil {
+			alreadyRegistered, ok := err.(prometheus.AlreadyRegisteredError)
+			if !ok {
+				return leaderElectionMetrics{}, errors.WithStack(err)
+			}
+			*vec = alreadyRegistered.ExistingCollector.(*prometheus.HistogramVec)
+		}
+	}
+	return metrics, nil
+}
+
+// handoverLock measures how long the lock was available before it was acquired. The lock is available from
+// the moment the previous leader released it or its lease expired. Release time is recorded as the renew time
+// of the released record because the leader elector leaves it empty.
+type handoverLock struct {
+	resourcelock.Interface
+
+	mu sync.Mutex
+	// observed is the last record returned by Get.
+	observed *resourcelock.LeaderElectionRecord
+	// handover is the handover time measured when the lock was last acquired.
+	handover    time.Duration
+	hasHandover bool
+}
+
+func (l *handoverLock) Get() (*resourcelock.LeaderElectionRecord, error) {
+	record, err := l.Interface.Get()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err != nil {
+		l.observed = nil
+		return nil, err
+	}
+	observed := *record
+	l.observed = &observed
+	return record, nil
+}
+
+func (l *handoverLock) Update(ler resourcelock.LeaderElectionRecord) error {
+	now := time.Now()
+	if ler.HolderIdentity == "" {
+		// Lock is being released
+		ler.RenewTime = meta_v1.NewTime(now)
+	}
+	if err := l.Interface.Update(ler); err != nil {
+		return err
+	}
+	if ler.HolderIdentity != l.Identity() {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	previous := l.observed
+	if previous == nil || previous.HolderIdentity == l.Identity() || previous.RenewTime.IsZero() {
+		// Lock is renewed or it is not known when it became available
+		return nil
+	}
+	availableSince := previous.RenewTime.Time
+	if previous.HolderIdentity != "" {
+		// Lock was not released, it became available when the lease expired
+		availableSince = availableSince.Add(time.Duration(previous.LeaseDurationSeconds) * time.Second)
+	}
+	l.handover = now.Sub(availableSince)
+	if l.handover < 0 {
+		l.handover = 0
+	}
+	l.hasHandover = true
+	return nil
+}
+
+// takeHandover returns the handover time measured when the lock was last acquired. Returns false if the lock
+// was created rather than taken over or if the handover time has already been taken.
+func (l *handoverLock) takeHandover() (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	hasHandover := l.hasHandover
+	l.hasHandover = false
+	return l.handover, hasHandover
+}
+
+func newResourceLock(config LeaderElectionOptions, client kubernetes.Interface, rlc resourcelock.ResourceLockConfig) (resourcelock.Interface, error) {
+	if config.ResourceLock != ConfigMapsLeasesResourceLock {
+		return resourcelock.New(config.ResourceLock, config.ResourceNamespace, config.ResourceName, client.CoreV1(), client.CoordinationV1(), rlc)
+	}
+	primary, err := resourcelock.New(resourcelock.ConfigMapsResourceLock, config.ResourceNamespace, config.ResourceName, client.CoreV1(), client.CoordinationV1(), rlc)
+	if err != nil {
+		return nil, err
+	}
+	secondary, err := resourcelock.New(resourcelock.LeasesResourceLock, config.ResourceNamespace, config.ResourceName, client.CoreV1(), client.CoordinationV1(), rlc)
+	if err != nil {
+		return nil, err
 	}
+	return &multiLock{
+		primary:   primary,
+		secondary: secondary,
+	}, nil
 }
 
 func BindLeaderElectionFlags(component string, o *LeaderElectionOptions, fs ctrl.FlagSet) {
@@ -103,8 +320,27 @@ func BindLeaderElectionFlags(component string, o *LeaderElectionOptions, fs ctrl
 	fs.DurationVar(&o.RetryPeriod, "leader-elect-retry-period", defaultRetryPeriod, ""+
 		"The duration the clients should wait between attempting acquisition and renewal "+
 		"of a leadership. This is only applicable if leader election is enabled")
-	fs.StringVar(&o.ConfigMapNamespace, "leader-elect-configmap-namespace", meta_v1.NamespaceDefault,
-		"Namespace to use for leader election ConfigMap. This is only applicable if leader election is enabled")
-	fs.StringVar(&o.ConfigMapName, "leader-elect-configmap-name", component+"-leader-elect",
-		"ConfigMap name to use for leader election. This is only applicable if leader election is enabled")
+	fs.StringVar(&o.ResourceLock, "leader-elect-resource-lock", resourcelock.ConfigMapsResourceLock, ""+
+		"The type of resource object that is used for locking during leader election. Supported options are "+
+		"'leases', 'configmaps' and 'configmapsleases'. 'configmapsleases' is used to migrate from 'configmaps' "+
+		"to 'leases'. This is only applicable if leader election is enabled")
+	fs.StringVar(&o.ResourceNamespace, "leader-elect-resource-namespace", meta_v1.NamespaceDefault,
+		"Namespace of the resource object that is used for locking during leader election. This is only applicable if leader election is enabled")
+	fs.StringVar(&o.ResourceName, "leader-elect-resource-name", component+"-leader-elect",
+		"Name of the resource object that is used for locking during leader election. This is only applicable if leader election is enabled")
+	fs.StringVar(&o.Identity, "leader-elect-identity", "",
+		"Identity of this leader election candidate. Hostname and application name are used if empty. This is only applicable if leader election is enabled")
+	fs.BoolVar(&o.ReleaseOnCancel, "leader-elect-release-on-cancel", false, ""+
+		"Release the lease on graceful shutdown after all work has stopped so that another candidate can "+
+		"acquire it without waiting for the lease to expire. This is only applicable if leader election is enabled")
+	fs.BoolVar(&o.WarmStandby, "leader-elect-warm-standby", false, ""+
+		"Start and sync informers on replicas that are not leading so that on failover the new leader "+
+		"can start processing immediately. Workers are only run by the leader. This is only applicable if leader election is enabled")
+	fs.BoolVar(&o.StandbyRunControllers, "leader-elect-standby-run-controllers", false, ""+
+		"Run controllers on replicas that are not leading. Only applicable if warm standby is enabled")
+	// Deprecated flags that are bound to the same fields as their replacements
+	fs.StringVar(&o.ResourceNamespace, "leader-elect-configmap-namespace", meta_v1.NamespaceDefault,
+		"Deprecated: use --leader-elect-resource-namespace instead")
+	fs.StringVar(&o.ResourceName, "leader-elect-configmap-name", component+"-leader-elect",
+		"Deprecated: use --leader-elect-resource-name instead")
 }
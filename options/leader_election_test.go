@@ -0,0 +1,118 @@
+package options
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+func testLeaderElectionOptions(identity string) LeaderElectionOptions {
+	return LeaderElectionOptions{
+		LeaderElect:       true,
+		LeaseDuration:     2 * time.Second,
+		RenewDeadline:     1 * time.Second,
+		RetryPeriod:       100 * time.Millisecond,
+		ResourceLock:      resourcelock.LeasesResourceLock,
+		ResourceNamespace: "ns",
+		ResourceName:      "lock",
+		Identity:          identity,
+		ReleaseOnCancel:   true,
+	}
+}
+
+func leaseHolder(t *testing.T, client kubernetes.Interface) string {
+	record, err := newTestLock(t, client, resourcelock.LeasesResourceLock, "observer").Get()
+	require.NoError(t, err)
+	return record.HolderIdentity
+}
+
+func histogramSampleCount(t *testing.T, registry *prometheus.Registry, name string) uint64 {
+	metricFamilies, err := registry.Gather()
+	require.NoError(t, err)
+	for _, mf := range metricFamilies {
+		if mf.GetName() == name {
+			require.Len(t, mf.Metric, 1)
+			return mf.Metric[0].GetHistogram().GetSampleCount()
+		}
+	}
+	return 0
+}
+
+func TestLeaderElectionReleasesLeaseAfterWorkStopped(t *testing.T) {
+	t.Parallel()
+
+	client := fake.NewSimpleClientset()
+	logger := zaptest.NewLogger(t)
+
+	registryA := prometheus.NewPedanticRegistry()
+	ctxA, cancelA := context.WithCancel(context.Background())
+	defer cancelA()
+	leCtxA, releaseA, err := DoLeaderElection(ctxA, logger, "test", testLeaderElectionOptions("a"), client, nil, registryA)
+	require.NoError(t, err)
+	assert.Equal(t, "a", leaseHolder(t, client))
+	assert.EqualValues(t, 1, histogramSampleCount(t, registryA, "ctrl_leader_election_acquire_seconds"))
+	// Lease was created, not taken over
+	assert.Zero(t, histogramSampleCount(t, registryA, "ctrl_leader_election_handover_seconds"))
+
+	cancelA()
+	select {
+	case <-leCtxA.Done():
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "leader election context was not cancelled")
+	}
+	// Lease is held until work has stopped and release is called
+	time.Sleep(300 * time.Millisecond)
+	assert.Equal(t, "a", leaseHolder(t, client))
+
+	releaseA()
+	assert.Empty(t, leaseHolder(t, client))
+	assert.EqualValues(t, 1, histogramSampleCount(t, registryA, "ctrl_leader_election_release_seconds"))
+
+	// Released lease is acquired without waiting for it to expire
+	registryB := prometheus.NewPedanticRegistry()
+	ctxB, cancelB := context.WithTimeout(context.Background(), time.Second)
+	defer cancelB()
+	_, releaseB, err := DoLeaderElection(ctxB, logger, "test", testLeaderElectionOptions("b"), client, nil, registryB)
+	require.NoError(t, err)
+	defer releaseB()
+	assert.Equal(t, "b", leaseHolder(t, client))
+	assert.EqualValues(t, 1, histogramSampleCount(t, registryB, "ctrl_leader_election_handover_seconds"))
+}
+
+func TestHandoverLockMeasuresFromLeaseExpiry(t *testing.T) {
+	t.Parallel()
+
+	client := fake.NewSimpleClientset()
+	record := testRecord("a")
+	record.LeaseDurationSeconds = 1
+	record.RenewTime.Time = record.RenewTime.Add(-3 * time.Second)
+	require.NoError(t, newTestLock(t, client, resourcelock.LeasesResourceLock, "a").Create(record))
+
+	lock := &handoverLock{
+		Interface: newTestLock(t, client, resourcelock.LeasesResourceLock, "b"),
+	}
+	_, err := lock.Get()
+	require.NoError(t, err)
+	require.NoError(t, lock.Update(testRecord("b")))
+
+	handover, ok := lock.takeHandover()
+	require.True(t, ok)
+	assert.True(t, handover >= 2*time.Second && handover < 3*time.Second, "handover time %s", handover)
+	_, ok = lock.takeHandover()
+	assert.False(t, ok)
+
+	// Renewal is not a handover
+	_, err = lock.Get()
+	require.NoError(t, err)
+	require.NoError(t, lock.Update(testRecord("b")))
+	_, ok = lock.takeHandover()
+	assert.False(t, ok)
+}
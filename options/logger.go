@@ -4,6 +4,7 @@ import (
 	"os"
 
 	"github.com/atlassian/ctrl"
+	"github.com/atlassian/ctrl/loglevel"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
@@ -34,22 +35,33 @@ func Logger(level zapcore.Level, encoder func(zapcore.EncoderConfig) zapcore.Enc
 }
 
 func LoggerFromOptions(o LoggerOptions) *zap.Logger {
-	var levelEnabler zapcore.Level
+	return Logger(logLevel(o), logEncoder(o))
+}
+
+// LoggerWithLevelsFromOptions returns a logger and a registry that can be used to change the level of the logger
+// and of loggers of individual controllers at runtime.
+func LoggerWithLevelsFromOptions(o LoggerOptions) (*zap.Logger, *loglevel.Registry) {
+	levels := loglevel.NewRegistry(logLevel(o))
+	// Levels are controlled by the registry so the core must enable all of them
+	return levels.Logger(Logger(zap.DebugLevel, logEncoder(o))), levels
+}
+
+func logLevel(o LoggerOptions) zapcore.Level {
 	switch o.LogLevel {
 	case "debug":
-		levelEnabler = zap.DebugLevel
+		return zap.DebugLevel
 	case "warn":
-		levelEnabler = zap.WarnLevel
+		return zap.WarnLevel
 	case "error":
-		levelEnabler = zap.ErrorLevel
+		return zap.ErrorLevel
 	default:
-		levelEnabler = zap.InfoLevel
+		return zap.InfoLevel
 	}
-	var logEncoder func(zapcore.EncoderConfig) zapcore.Encoder
+}
+
+func logEncoder(o LoggerOptions) func(zapcore.EncoderConfig) zapcore.Encoder {
 	if o.LogEncoding == "console" {
-		logEncoder = zapcore.NewConsoleEncoder
-	} else {
-		logEncoder = zapcore.NewJSONEncoder
+		return zapcore.NewConsoleEncoder
 	}
-	return Logger(levelEnabler, logEncoder)
+	return zapcore.NewJSONEncoder
 }
@@ -0,0 +1,76 @@
+package options
+
+import (
+	"fmt"
+
+	api_errors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+const (
+	// unknownLeader is the identity reported when primary and secondary locks have different holders.
+	unknownLeader = "leaderelection.k8s.io/unknown"
+)
+
+// multiLock is used for migration from one resource lock type to another. The lock is acquired and renewed
+// in both primary and secondary resources, so that both old (primary only) and new (secondary only)
+// clients observe the same leader.
+type multiLock struct {
+	primary   resourcelock.Interface
+	secondary resourcelock.Interface
+}
+
+func (ml *multiLock) Get() (*resourcelock.LeaderElectionRecord, error) {
+	primary, err := ml.primary.Get()
+	if err != nil {
+		return nil, err
+	}
+	secondary, err := ml.secondary.Get()
+	if err != nil {
+		// Lock is held by an old client that does not know about the secondary resource
+		if api_errors.IsNotFound(err) && primary.HolderIdentity != ml.Identity() {
+			return primary, nil
+		}
+		return nil, err
+	}
+	if primary.HolderIdentity != secondary.HolderIdentity {
+		primary.HolderIdentity = unknownLeader
+	}
+	return primary, nil
+}
+
+func (ml *multiLock) Create(ler resourcelock.LeaderElectionRecord) error {
+	err := ml.primary.Create(ler)
+	if err != nil && !api_errors.IsAlreadyExists(err) {
+		return err
+	}
+	return ml.secondary.Create(ler)
+}
+
+func (ml *multiLock) Update(ler resourcelock.LeaderElectionRecord) error {
+	err := ml.primary.Update(ler)
+	if err != nil {
+		return err
+	}
+	_, err = ml.secondary.Get()
+	if err != nil {
+		if api_errors.IsNotFound(err) {
+			return ml.secondary.Create(ler)
+		}
+		return err
+	}
+	return ml.secondary.Update(ler)
+}
+
+func (ml *multiLock) RecordEvent(s string) {
+	ml.primary.RecordEvent(s)
+	ml.secondary.RecordEvent(s)
+}
+
+func (ml *multiLock) Identity() string {
+	return ml.primary.Identity()
+}
+
+func (ml *multiLock) Describe() string {
+	return fmt.Sprintf("%s, %s", ml.primary.Describe(), ml.secondary.Describe())
+}
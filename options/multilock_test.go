@@ -0,0 +1,77 @@
+package options
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	api_errors "k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+func newTestLock(t *testing.T, client kubernetes.Interface, lockType, identity string) resourcelock.Interface {
+	lock, err := newResourceLock(LeaderElectionOptions{
+		ResourceLock:      lockType,
+		ResourceNamespace: "ns",
+		ResourceName:      "lock",
+	}, client, resourcelock.ResourceLockConfig{
+		Identity: identity,
+	})
+	require.NoError(t, err)
+	return lock
+}
+
+func testRecord(identity string) resourcelock.LeaderElectionRecord {
+	now := meta_v1.NewTime(time.Now())
+	return resourcelock.LeaderElectionRecord{
+		HolderIdentity:       identity,
+		LeaseDurationSeconds: 15,
+		AcquireTime:          now,
+		RenewTime:            now,
+	}
+}
+
+func TestMultiLockCreatesBothLocks(t *testing.T) {
+	t.Parallel()
+
+	client := fake.NewSimpleClientset()
+	lock := newTestLock(t, client, ConfigMapsLeasesResourceLock, "a")
+
+	_, err := lock.Get()
+	require.True(t, api_errors.IsNotFound(err))
+	require.NoError(t, lock.Create(testRecord("a")))
+
+	record, err := lock.Get()
+	require.NoError(t, err)
+	assert.Equal(t, "a", record.HolderIdentity)
+
+	// Both old and new clients observe the same holder
+	record, err = newTestLock(t, client, resourcelock.ConfigMapsResourceLock, "b").Get()
+	require.NoError(t, err)
+	assert.Equal(t, "a", record.HolderIdentity)
+	record, err = newTestLock(t, client, resourcelock.LeasesResourceLock, "b").Get()
+	require.NoError(t, err)
+	assert.Equal(t, "a", record.HolderIdentity)
+}
+
+func TestMultiLockRespectsOldClient(t *testing.T) {
+	t.Parallel()
+
+	client := fake.NewSimpleClientset()
+	require.NoError(t, newTestLock(t, client, resourcelock.ConfigMapsResourceLock, "old").Create(testRecord("old")))
+
+	lock := newTestLock(t, client, ConfigMapsLeasesResourceLock, "new")
+	record, err := lock.Get()
+	require.NoError(t, err)
+	assert.Equal(t, "old", record.HolderIdentity)
+
+	// Taking over the lock creates the secondary lock
+	require.NoError(t, lock.Update(testRecord("new")))
+	record, err = newTestLock(t, client, resourcelock.LeasesResourceLock, "b").Get()
+	require.NoError(t, err)
+	assert.Equal(t, "new", record.HolderIdentity)
+}
@@ -0,0 +1,75 @@
+package options
+
+import (
+	"os"
+	"time"
+
+	"github.com/atlassian/ctrl"
+	"github.com/pkg/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	defaultShardLeaseDuration = 15 * time.Second
+	defaultShardRenewPeriod   = 5 * time.Second
+)
+
+// ShardingOptions configure key-based sharding of work across replicas.
+type ShardingOptions struct {
+	Shard bool
+	// ShardNamespace is the namespace where Leases of the shard group members are stored.
+	ShardNamespace string
+	// ShardGroup is the name of the shard group.
+	ShardGroup string
+	// ShardIdentity of this replica. Hostname and component name are used if empty.
+	ShardIdentity      string
+	ShardLeaseDuration time.Duration
+	ShardRenewPeriod   time.Duration
+	// ShardByNamespace makes all objects in a namespace owned by the same replica.
+	ShardByNamespace bool
+}
+
+func (o *ShardingOptions) DefaultAndValidate(component string) []error {
+	var allErrors []error
+	if !o.Shard {
+		return allErrors
+	}
+	if o.ShardIdentity == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			allErrors = append(allErrors, errors.Wrap(err, "failed to get hostname for shard identity"))
+		}
+		o.ShardIdentity = hostname + "-" + component
+	}
+	if o.ShardGroup == "" {
+		allErrors = append(allErrors, errors.New("shard group name must not be empty"))
+	}
+	if o.ShardLeaseDuration < time.Second {
+		allErrors = append(allErrors, errors.Errorf("shard lease duration must be at least one second. Given: %s", o.ShardLeaseDuration))
+	}
+	if o.ShardRenewPeriod <= 0 || o.ShardRenewPeriod >= o.ShardLeaseDuration {
+		allErrors = append(allErrors, errors.Errorf("shard renew period %s must be positive and less than the lease duration %s", o.ShardRenewPeriod, o.ShardLeaseDuration))
+	}
+	return allErrors
+}
+
+func BindShardingFlags(component string, o *ShardingOptions, fs ctrl.FlagSet) {
+	fs.BoolVar(&o.Shard, "shard", false, ""+
+		"Distribute objects between replicas so that each replica only processes objects it owns. "+
+		"Replicas discover each other using Leases. Cannot be used together with leader election")
+	fs.StringVar(&o.ShardNamespace, "shard-namespace", meta_v1.NamespaceDefault,
+		"Namespace of Leases that are used for discovery of replicas. This is only applicable if sharding is enabled")
+	fs.StringVar(&o.ShardGroup, "shard-group", component+"-shard",
+		"Name of the group of replicas that share work. This is only applicable if sharding is enabled")
+	fs.StringVar(&o.ShardIdentity, "shard-identity", "",
+		"Identity of this replica. Hostname and application name are used if empty. This is only applicable if sharding is enabled")
+	fs.DurationVar(&o.ShardLeaseDuration, "shard-lease-duration", defaultShardLeaseDuration, ""+
+		"The duration after which a replica that has not renewed its Lease is considered gone and its objects "+
+		"are distributed between other replicas. Replicas also wait this long before processing objects they have "+
+		"become owners of. This is only applicable if sharding is enabled")
+	fs.DurationVar(&o.ShardRenewPeriod, "shard-renew-period", defaultShardRenewPeriod, ""+
+		"The interval between renewals of the replica's Lease and checks for changes of the group. "+
+		"This must be less than the lease duration. This is only applicable if sharding is enabled")
+	fs.BoolVar(&o.ShardByNamespace, "shard-by-namespace", false,
+		"Make all objects in a namespace owned by the same replica. This is only applicable if sharding is enabled")
+}
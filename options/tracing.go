@@ -0,0 +1,68 @@
+package options
+
+import (
+	"context"
+
+	"github.com/atlassian/ctrl"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+)
+
+const (
+	DefaultTracingSampleRatio = 1
+)
+
+// TracingOptions configure export of traces via OTLP over HTTP.
+type TracingOptions struct {
+	// TracingOTLPEndpoint is the host:port of the OTLP HTTP endpoint to export traces to.
+	// Tracing is disabled if empty.
+	TracingOTLPEndpoint string
+	// TracingOTLPInsecure disables TLS when exporting traces.
+	TracingOTLPInsecure bool
+	// TracingSampleRatio is the fraction of traces that are sampled. Spans of sampled remote parents are always sampled.
+	TracingSampleRatio float64
+}
+
+func (o *TracingOptions) DefaultAndValidate() []error {
+	var allErrors []error
+	if o.TracingSampleRatio < 0 || o.TracingSampleRatio > 1 {
+		allErrors = append(allErrors, errors.Errorf("value for tracing sample ratio must be between 0 and 1. Given: %f", o.TracingSampleRatio))
+	}
+	return allErrors
+}
+
+func BindTracingFlags(o *TracingOptions, fs ctrl.FlagSet) {
+	fs.StringVar(&o.TracingOTLPEndpoint, "tracing-otlp-endpoint", "",
+		"host:port of the OTLP HTTP endpoint to export traces to. Tracing is disabled if empty")
+	fs.BoolVar(&o.TracingOTLPInsecure, "tracing-otlp-insecure", false,
+		"Disables TLS when exporting traces. This is only applicable if --tracing-otlp-endpoint is set")
+	fs.Float64Var(&o.TracingSampleRatio, "tracing-sample-ratio", DefaultTracingSampleRatio,
+		"Fraction of traces to sample, between 0 and 1. This is only applicable if --tracing-otlp-endpoint is set")
+}
+
+// NewTracerProvider constructs a tracer provider that exports spans of the service to the OTLP endpoint.
+// Returns nil if tracing is disabled. The provider must be shut down to flush spans that have not been exported.
+func NewTracerProvider(serviceName string, options TracingOptions) (*sdktrace.TracerProvider, error) {
+	if options.TracingOTLPEndpoint == "" {
+		return nil, nil
+	}
+	clientOptions := []otlptracehttp.Option{
+		otlptracehttp.WithEndpoint(options.TracingOTLPEndpoint),
+	}
+	if options.TracingOTLPInsecure {
+		clientOptions = append(clientOptions, otlptracehttp.WithInsecure())
+	}
+	// Exporter does not connect to the endpoint until spans are exported
+	exporter, err := otlptracehttp.New(context.Background(), clientOptions...)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to construct OTLP trace exporter")
+	}
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String(serviceName))),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(options.TracingSampleRatio))),
+	), nil
+}
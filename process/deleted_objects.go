@@ -0,0 +1,77 @@
+package process
+
+import (
+	"sync"
+
+	"github.com/atlassian/ctrl"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// deletedObjects holds the last known state of deleted objects until the controller processes their deletion.
+// All methods can be called on a nil pointer, it is used for controllers that do not process deletions.
+type deletedObjects struct {
+	mu      sync.Mutex
+	objects map[ctrl.QueueKey]runtime.Object
+	// owns returns true if the key is owned by this replica. Objects with keys that are not owned are not stored.
+	owns func(ctrl.QueueKey) bool
+}
+
+func newDeletedObjects() *deletedObjects {
+	return &deletedObjects{
+		objects: make(map[ctrl.QueueKey]runtime.Object),
+	}
+}
+
+// Put stores the last known state of a deleted object. Object must not be mutated.
+func (d *deletedObjects) Put(key ctrl.QueueKey, obj runtime.Object) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.owns != nil && !d.owns(key) {
+		return
+	}
+	d.objects[key] = obj
+}
+
+func (d *deletedObjects) setOwns(owns func(ctrl.QueueKey) bool) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.owns = owns
+}
+
+func (d *deletedObjects) get(key ctrl.QueueKey) runtime.Object {
+	if d == nil {
+		return nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.objects[key]
+}
+
+// keys returns keys of all stored objects.
+func (d *deletedObjects) keys() []ctrl.QueueKey {
+	if d == nil {
+		return nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	keys := make([]ctrl.QueueKey, 0, len(d.objects))
+	for key := range d.objects {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// remove removes the object if it is still the last known state of the object with the key.
+func (d *deletedObjects) remove(key ctrl.QueueKey, obj runtime.Object) {
+	if d == nil || obj == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.objects[key] == obj {
+		delete(d.objects, key)
+	}
+}
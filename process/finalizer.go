@@ -0,0 +1,176 @@
+package process
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/atlassian/ctrl"
+	"github.com/atlassian/ctrl/logz"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	api_errors "k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// finalizer manages a controller's finalizer on objects the controller processes.
+type finalizer struct {
+	ctrl.Finalizer
+	// resource is patched with the dynamic client of the cluster of the object being processed.
+	resource schema.GroupVersionResource
+
+	mu sync.Mutex
+	// pending holds deletion timestamps of objects that have been marked for deletion but still have the finalizer.
+	pending map[ctrl.QueueKey]time.Time
+}
+
+func newFinalizer(f ctrl.Finalizer, resource schema.GroupVersionResource) *finalizer {
+	return &finalizer{
+		Finalizer: f,
+		resource:  resource,
+		pending:   make(map[ctrl.QueueKey]time.Time),
+	}
+}
+
+// process adds the finalizer to objects that don't have it yet, passes objects that are marked for deletion
+// to the cleanup function and removes the finalizer once cleanup succeeds. Other objects are passed to process.
+func (f *finalizer) process(pctx *ctrl.ProcessContext, process func(*ctrl.ProcessContext) (ctrl.ProcessResult, error)) (ctrl.ProcessResult, error) {
+	metaObj := pctx.Object.(meta_v1.Object)
+	key := ctrl.QueueKey{
+		Cluster:   pctx.Cluster.Name,
+		Namespace: metaObj.GetNamespace(),
+		Name:      metaObj.GetName(),
+	}
+	finalizers := metaObj.GetFinalizers()
+	hasFinalizer := false
+	var otherFinalizers []string
+	for _, name := range finalizers {
+		if name == f.Name {
+			hasFinalizer = true
+		} else {
+			otherFinalizers = append(otherFinalizers, name)
+		}
+	}
+
+	deletionTimestamp := metaObj.GetDeletionTimestamp()
+	if deletionTimestamp == nil {
+		if hasFinalizer {
+			return process(pctx)
+		}
+		// Update triggers an informer event so the object will be processed again
+		pctx.Logger.Info("Adding finalizer", logz.Finalizer(f.Name))
+		return ctrl.ProcessResult{}, f.patchFinalizers(pctx.Cluster, metaObj, append(finalizers, f.Name))
+	}
+
+	if !hasFinalizer {
+		f.forget(key)
+		pctx.Logger.Debug("Object is marked for deletion and has no finalizer")
+		return ctrl.ProcessResult{}, nil
+	}
+
+	f.mu.Lock()
+	f.pending[key] = deletionTimestamp.Time
+	f.mu.Unlock()
+
+	result, err := f.Cleanup(pctx)
+	if err != nil || result.Requeue || result.RequeueAfter > 0 {
+		return result, err
+	}
+	pctx.Logger.Info("Removing finalizer", logz.Finalizer(f.Name))
+	if err = f.patchFinalizers(pctx.Cluster, metaObj, otherFinalizers); err != nil && !api_errors.IsNotFound(errors.Cause(err)) {
+		return ctrl.ProcessResult{}, err
+	}
+	f.forget(key)
+	return ctrl.ProcessResult{}, nil
+}
+
+// forget stops tracking the object as pending finalization. Can be called on a nil pointer.
+func (f *finalizer) forget(key ctrl.QueueKey) {
+	if f == nil {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.pending, key)
+}
+
+// pendingStats returns the number of objects pending finalization and for how long the oldest one has been pending.
+func (f *finalizer) pendingStats(now time.Time) (int, time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var oldest time.Duration
+	for _, deletionTimestamp := range f.pending {
+		if age := now.Sub(deletionTimestamp); age > oldest {
+			oldest = age
+		}
+	}
+	return len(f.pending), oldest
+}
+
+func (f *finalizer) patchFinalizers(cluster ctrl.Cluster, metaObj meta_v1.Object, finalizers []string) error {
+	if cluster.DynamicClient == nil {
+		return errors.Errorf("no dynamic client for cluster %q", cluster.Name)
+	}
+	if finalizers == nil {
+		finalizers = []string{}
+	}
+	// resourceVersion makes the patch fail with a conflict if the object has been updated concurrently
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"finalizers":      finalizers,
+			"resourceVersion": metaObj.GetResourceVersion(),
+		},
+	})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	_, err = cluster.DynamicClient.Resource(f.resource).Namespace(metaObj.GetNamespace()).Patch(metaObj.GetName(), types.MergePatchType, patch, meta_v1.PatchOptions{})
+	if err != nil {
+		return ctrl.NewRetriableError(errors.Wrap(err, "failed to update finalizers"))
+	}
+	return nil
+}
+
+// finalizerCollector exports metrics about objects that are pending finalization.
+type finalizerCollector struct {
+	holders          map[schema.GroupVersionKind]Holder
+	pendingObjects   *prometheus.Desc
+	oldestPendingAge *prometheus.Desc
+}
+
+func newFinalizerCollector(holders map[schema.GroupVersionKind]Holder) *finalizerCollector {
+	labels := []string{"controller", "groupkind", "finalizer"}
+	return &finalizerCollector{
+		holders: holders,
+		pendingObjects: prometheus.NewDesc(
+			prometheus.BuildFQName(metricsNamespace, "", "finalizer_pending_objects"),
+			"Number of objects that have been marked for deletion and are waiting for the finalizer to be removed",
+			labels, nil,
+		),
+		oldestPendingAge: prometheus.NewDesc(
+			prometheus.BuildFQName(metricsNamespace, "", "finalizer_oldest_pending_seconds"),
+			"Number of seconds since the oldest object waiting for the finalizer to be removed was marked for deletion",
+			labels, nil,
+		),
+	}
+}
+
+func (c *finalizerCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.pendingObjects
+	ch <- c.oldestPendingAge
+}
+
+func (c *finalizerCollector) Collect(ch chan<- prometheus.Metric) {
+	now := time.Now()
+	for gvk, holder := range c.holders {
+		if holder.finalizer == nil {
+			continue
+		}
+		pending, oldest := holder.finalizer.pendingStats(now)
+		labels := []string{holder.AppName, gvk.GroupKind().String(), holder.finalizer.Name}
+		ch <- prometheus.MustNewConstMetric(c.pendingObjects, prometheus.GaugeValue, float64(pending), labels...)
+		ch <- prometheus.MustNewConstMetric(c.oldestPendingAge, prometheus.GaugeValue, oldest.Seconds(), labels...)
+	}
+}
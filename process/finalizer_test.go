@@ -0,0 +1,108 @@
+package process
+
+import (
+	"testing"
+	"time"
+
+	"github.com/atlassian/ctrl"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+	core_v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic/fake"
+)
+
+const testFinalizer = "example.com/cleanup"
+
+// newTestFinalizer returns a finalizer and the cluster of obj.
+func newTestFinalizer(t *testing.T, obj *core_v1.ConfigMap, cleanup func(*ctrl.ProcessContext) (ctrl.ProcessResult, error)) (*finalizer, ctrl.Cluster) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, core_v1.AddToScheme(scheme))
+	cluster := ctrl.Cluster{
+		DynamicClient: fake.NewSimpleDynamicClient(scheme, obj),
+	}
+	return newFinalizer(ctrl.Finalizer{
+		Name:     testFinalizer,
+		Resource: "configmaps",
+		Cleanup:  cleanup,
+	}, core_v1.SchemeGroupVersion.WithResource("configmaps")), cluster
+}
+
+func getFinalizers(t *testing.T, f *finalizer, cluster ctrl.Cluster, namespace, name string) []string {
+	obj, err := cluster.DynamicClient.Resource(f.resource).Namespace(namespace).Get(name, meta_v1.GetOptions{})
+	require.NoError(t, err)
+	return obj.GetFinalizers()
+}
+
+func TestFinalizerIsAdded(t *testing.T) {
+	t.Parallel()
+
+	obj := &core_v1.ConfigMap{
+		TypeMeta:   meta_v1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"},
+		ObjectMeta: meta_v1.ObjectMeta{Namespace: "ns", Name: "cm", Finalizers: []string{"other"}},
+	}
+	f, cluster := newTestFinalizer(t, obj, func(*ctrl.ProcessContext) (ctrl.ProcessResult, error) {
+		t.Error("unexpected cleanup")
+		return ctrl.ProcessResult{}, nil
+	})
+
+	_, err := f.process(&ctrl.ProcessContext{
+		Logger:  zaptest.NewLogger(t),
+		Object:  obj.DeepCopy(),
+		Cluster: cluster,
+	}, func(*ctrl.ProcessContext) (ctrl.ProcessResult, error) {
+		t.Error("unexpected process")
+		return ctrl.ProcessResult{}, nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"other", testFinalizer}, getFinalizers(t, f, cluster, "ns", "cm"))
+}
+
+func TestFinalizerIsRemovedAfterCleanup(t *testing.T) {
+	t.Parallel()
+
+	deletionTimestamp := meta_v1.NewTime(time.Now().Add(-time.Minute))
+	obj := &core_v1.ConfigMap{
+		TypeMeta: meta_v1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"},
+		ObjectMeta: meta_v1.ObjectMeta{
+			Namespace:         "ns",
+			Name:              "cm",
+			Finalizers:        []string{testFinalizer, "other"},
+			DeletionTimestamp: &deletionTimestamp,
+		},
+	}
+	cleanupErr := ctrl.NewRetriableError(assert.AnError)
+	f, cluster := newTestFinalizer(t, obj, func(*ctrl.ProcessContext) (ctrl.ProcessResult, error) {
+		return ctrl.ProcessResult{}, cleanupErr
+	})
+	process := func(*ctrl.ProcessContext) (ctrl.ProcessResult, error) {
+		t.Error("unexpected process")
+		return ctrl.ProcessResult{}, nil
+	}
+
+	// Failed cleanup keeps the finalizer and the object is pending
+	_, err := f.process(&ctrl.ProcessContext{
+		Logger:  zaptest.NewLogger(t),
+		Object:  obj.DeepCopy(),
+		Cluster: cluster,
+	}, process)
+	require.Equal(t, cleanupErr, err)
+	assert.Equal(t, []string{testFinalizer, "other"}, getFinalizers(t, f, cluster, "ns", "cm"))
+	pending, oldest := f.pendingStats(time.Now())
+	assert.Equal(t, 1, pending)
+	assert.True(t, oldest >= time.Minute)
+
+	// Successful cleanup removes the finalizer
+	cleanupErr = nil
+	_, err = f.process(&ctrl.ProcessContext{
+		Logger:  zaptest.NewLogger(t),
+		Object:  obj.DeepCopy(),
+		Cluster: cluster,
+	}, process)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"other"}, getFinalizers(t, f, cluster, "ns", "cm"))
+	pending, _ = f.pendingStats(time.Now())
+	assert.Zero(t, pending)
+}
@@ -3,19 +3,30 @@ package process
 import (
 	"context"
 	"net/http"
+	"strconv"
+	"sync/atomic"
 	"time"
 
 	"github.com/ash2k/stager"
 	"github.com/atlassian/ctrl"
 	"github.com/atlassian/ctrl/handlers"
+	"github.com/atlassian/ctrl/healthz"
+	"github.com/atlassian/ctrl/loglevel"
 	"github.com/atlassian/ctrl/logz"
+	"github.com/atlassian/ctrl/tracing"
 	chimw "github.com/go-chi/chi/middleware"
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 	"golang.org/x/sync/errgroup"
+	core_v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/workqueue"
 )
 
@@ -28,57 +39,202 @@ const (
 )
 
 type Generic struct {
-	iter        uint32
+	iter uint32
+	// synced is set to 1 once informers have synced.
+	synced int32
+	// electing is set to 1 if leader election is used to decide which controllers are run by this replica.
+	electing    int32
 	logger      *zap.Logger
-	queue       workQueue
-	workers     uint
 	Controllers map[schema.GroupVersionKind]Holder
 	Servers     map[schema.GroupVersionKind]ServerHolder
 	Informers   map[schema.GroupVersionKind]cache.SharedIndexInformer
+	// ClusterInformers are informers for additional clusters by cluster name.
+	ClusterInformers map[string]map[schema.GroupVersionKind]cache.SharedIndexInformer
+	// clusters are all clusters by name, including the main cluster.
+	clusters     map[string]ctrl.Cluster
+	healthConfig HealthConfig
+	slowObjects  *slowObjects
+	tracer       trace.Tracer
+	logLevels    *loglevel.Registry
+	// HealthChecks is the registry of health checks of all controllers and servers.
+	HealthChecks *healthz.Registry
 }
 
-func NewGeneric(config *ctrl.Config, queue workqueue.RateLimitingInterface, workers uint, constructors ...ctrl.Constructor) (*Generic, error) {
+// NewGeneric constructs controllers and servers using the constructors. Each controller gets its own work queue
+// and pool of workers. workers is the number of workers used for controllers that do not specify it
+// in their Descriptor.
+func NewGeneric(config *ctrl.Config, workers uint, constructors ...ctrl.Constructor) (*Generic, error) {
 	controllers := make(map[schema.GroupVersionKind]ctrl.Interface)
 	servers := make(map[schema.GroupVersionKind]ctrl.Server)
 	holders := make(map[schema.GroupVersionKind]Holder)
 	informers := make(map[schema.GroupVersionKind]cache.SharedIndexInformer)
+	informerSelectors := make(map[schema.GroupVersionKind]ctrl.Selector)
 	serverHolders := make(map[schema.GroupVersionKind]ServerHolder)
-	wq := workQueue{
-		queue:                   queue,
-		workDeduplicationPeriod: workDeduplicationPeriod,
+	metricsCardinality := config.ObjectMetricsCardinality
+	switch metricsCardinality {
+	case "":
+		metricsCardinality = ctrl.MetricsCardinalityGVK
+	case ctrl.MetricsCardinalityObject, ctrl.MetricsCardinalityNamespace, ctrl.MetricsCardinalityGVK:
+	default:
+		return nil, errors.Errorf("invalid object metrics cardinality %q", metricsCardinality)
 	}
+	healthChecks := config.HealthChecks
+	if healthChecks == nil {
+		healthChecks = healthz.NewRegistry()
+	}
+	clusterInformers := make(map[string]map[schema.GroupVersionKind]cache.SharedIndexInformer)
+	clusters := map[string]ctrl.Cluster{
+		"": config.MainCluster(),
+	}
+	for _, cluster := range config.Clusters {
+		if cluster.Name == "" {
+			return nil, errors.New("additional cluster must have a name")
+		}
+		if _, ok := clusters[cluster.Name]; ok {
+			return nil, errors.Errorf("duplicate cluster %q", cluster.Name)
+		}
+		clusters[cluster.Name] = cluster
+	}
+
+	// Metrics are shared by all controllers and servers, they are distinguished by the groupkind label
+	objectProcessTime := prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "process_object_seconds",
+			Help:      "Histogram measuring the time it took to process an object. Object labels are set according to the cardinality policy",
+		},
+		[]string{"controller", "object_namespace", "object", "groupkind"},
+	)
+	objectProcessErrors := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "process_object_errors_total",
+			Help:      "Records the number of times an error was triggered while processing an object. Object labels are set according to the cardinality policy",
+		},
+		[]string{"controller", "object_namespace", "object", "groupkind", "external", "retriable"},
+	)
+	objectProcessPanics := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "process_object_panics_total",
+			Help:      "Records the number of recovered panics that happened while processing an object",
+		},
+		[]string{"controller", "groupkind"},
+	)
+	// Extra api data
+	requestTime := prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "request_time_seconds",
+			Help:      "Number of seconds each request takes to the controller provided server",
+		},
+		[]string{"url", "method", "status", "controller", "groupkind"},
+	)
+	informerSelectorInfo := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "informer_selector_info",
+			Help:      "Selectors that restrict objects watched by informers. Always 1",
+		},
+		[]string{"controller", "groupkind", "label_selector", "field_selector"},
+	)
+	eventProcessLatency := prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "event_to_processed_seconds",
+			Help:      "Histogram measuring the time from an informer event to the end of successful processing of the object it caused to be enqueued",
+			Buckets:   prometheus.ExponentialBuckets(0.01, 3, 10),
+		},
+		[]string{"controller", "groupkind"},
+	)
+	queueAdds := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "workqueue",
+			Name:      "adds_total",
+			Help:      "Number of objects added to the work queue, including retries and delayed requeues",
+		},
+		[]string{"controller", "groupkind"},
+	)
+	queueRetries := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "workqueue",
+			Name:      "retries_total",
+			Help:      "Number of objects added back to the work queue with a rate limited delay",
+		},
+		[]string{"controller", "groupkind"},
+	)
+	queueDuration := prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "workqueue",
+			Name:      "queue_duration_seconds",
+			Help:      "Histogram measuring how long an object waits in the work queue after it becomes ready to be processed",
+			Buckets:   prometheus.ExponentialBuckets(0.001, 4, 10),
+		},
+		[]string{"controller", "groupkind"},
+	)
+	workDuration := prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "workqueue",
+			Name:      "work_duration_seconds",
+			Help:      "Histogram measuring how long it takes to process an object taken off the work queue",
+			Buckets:   prometheus.ExponentialBuckets(0.001, 4, 10),
+		},
+		[]string{"controller", "groupkind"},
+	)
+	allMetrics := []prometheus.Collector{objectProcessTime, objectProcessErrors, objectProcessPanics, requestTime, informerSelectorInfo,
+		queueAdds, queueRetries, queueDuration, workDuration, eventProcessLatency}
+
 	for _, constr := range constructors {
 		descr := constr.Describe()
 
 		readyForWork := make(chan struct{})
-		queueGvk := wq.newQueueForGvk(descr.Gvk)
+		policy := retryPolicy(descr.RetryPolicy, config.RetryPolicy)
+		rateLimiter := descr.RateLimiter
+		if rateLimiter == nil {
+			rateLimiter = newRetryRateLimiter(policy)
+		}
+		queueName := descr.Gvk.String()
 		groupKind := descr.Gvk.GroupKind()
-		controllerLogger := config.Logger
-		constructorConfig := config
+		wq := newWorkQueue(rateLimiter, func() workqueue.DelayingInterface {
+			return workqueue.NewNamedDelayingQueue(queueName)
+		}, workDeduplicationPeriod, queueMetrics{
+			adds:          queueAdds.WithLabelValues(config.AppName, groupKind.String()),
+			retries:       queueRetries.WithLabelValues(config.AppName, groupKind.String()),
+			queueDuration: queueDuration.WithLabelValues(config.AppName, groupKind.String()),
+			workDuration:  workDuration.WithLabelValues(config.AppName, groupKind.String()),
+		})
+		queueGvk := wq.newQueueForGvk(descr.Gvk)
+		recorder, err := newRecorder(config, descr)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to construct event recorder for GVK %s", descr.Gvk)
+		}
+		tracingMiddleware := tracing.Middleware(config.TracerProvider,
+			tracing.ControllerKey.String(config.AppName),
+			tracing.GroupKindKey.String(groupKind.String()))
+		controllerLogger := config.LogLevels.ControllerLogger(config.Logger, descr.Gvk)
+		constructorConfig := *config
 		constructorConfig.Logger = controllerLogger
 
-		// Extra api data
-		requestTime := prometheus.NewHistogramVec(
-			prometheus.HistogramOpts{
-				Namespace: metricsNamespace,
-				Name:      "request_time_seconds",
-				Help:      "Number of seconds each request takes to the controller provided server",
-			},
-			[]string{"url", "method", "status", "controller", "groupkind"},
-		)
-
-		var allMetrics []prometheus.Collector
-
 		constructed, err := constr.New(
-			constructorConfig,
+			&constructorConfig,
 			&ctrl.Context{
 				ReadyForWork: func() {
 					close(readyForWork)
 				},
-				Middleware:  addMetricsMiddleware(requestTime, config.AppName, groupKind.String()),
+				Middleware:  chainMiddleware(tracingMiddleware, addMetricsMiddleware(requestTime, config.AppName, groupKind.String())),
 				Informers:   informers,
 				Controllers: controllers,
 				WorkQueue:   queueGvk,
+				Recorder:    recorder,
+				Descriptor:  descr,
+
+				InformerSelectors: informerSelectors,
+				ClusterInformers:  clusterInformers,
+				HealthChecks:      healthChecks,
 			},
 		)
 		if err != nil {
@@ -86,7 +242,33 @@ func NewGeneric(config *ctrl.Config, queue workqueue.RateLimitingInterface, work
 		}
 
 		if constructed.Interface == nil && constructed.Server == nil {
-			return nil, errors.Wrapf(err, "failed to construct controller or server for GVK %s", descr.Gvk)
+			return nil, errors.Errorf("failed to construct controller or server for GVK %s", descr.Gvk)
+		}
+
+		var fin *finalizer
+		if constructed.Finalizer != nil {
+			if constructed.Interface == nil {
+				return nil, errors.Errorf("finalizer for GVK %s requires a controller", descr.Gvk)
+			}
+			if constructed.Finalizer.Name == "" || constructed.Finalizer.Cleanup == nil {
+				return nil, errors.Errorf("finalizer for GVK %s must have a name and a cleanup function", descr.Gvk)
+			}
+			if config.DynamicClient == nil {
+				return nil, errors.Errorf("finalizer for GVK %s requires a dynamic client", descr.Gvk)
+			}
+			gvr, err := finalizerResource(config, descr.Gvk, constructed.Finalizer.Resource)
+			if err != nil {
+				return nil, err
+			}
+			fin = newFinalizer(*constructed.Finalizer, gvr)
+			for _, cluster := range config.Clusters {
+				if _, ok := clusterInformers[cluster.Name][descr.Gvk]; !ok {
+					continue
+				}
+				if cluster.DynamicClient == nil {
+					return nil, errors.Errorf("finalizer for GVK %s requires a dynamic client for cluster %q", descr.Gvk, cluster.Name)
+				}
+			}
 		}
 
 		if constructed.Interface != nil {
@@ -97,40 +279,64 @@ func NewGeneric(config *ctrl.Config, queue workqueue.RateLimitingInterface, work
 			if !ok {
 				return nil, errors.Errorf("controller for GVK %s should have registered an informer for that GVK", descr.Gvk)
 			}
-			inf.AddEventHandler(&handlers.GenericHandler{
+			handler := &handlers.GenericHandler{
 				Logger:    controllerLogger,
 				WorkQueue: queueGvk,
 				Gvk:       descr.Gvk,
-			})
+			}
+			var deleted *deletedObjects
+			if _, ok := constructed.Interface.(ctrl.DeletionProcessor); ok {
+				deleted = newDeletedObjects()
+				handler.DeletedObjects = deleted
+			}
+			inf.AddEventHandler(handler)
+			health := newControllerHealth()
+			health.trackInformer("", inf)
+			for clusterName, clusterInfs := range clusterInformers {
+				clusterInf, ok := clusterInfs[descr.Gvk]
+				if !ok {
+					continue
+				}
+				clusterHandler := *handler
+				clusterHandler.Cluster = clusterName
+				clusterInf.AddEventHandler(&clusterHandler)
+				health.trackInformer(clusterName, clusterInf)
+			}
 
 			controllers[descr.Gvk] = constructed.Interface
 
-			objectProcessTime := prometheus.NewHistogramVec(
-				prometheus.HistogramOpts{
-					Namespace: metricsNamespace,
-					Name:      "process_object_seconds",
-					Help:      "Histogram measuring the time it took to process an object",
-				},
-				[]string{"controller", "object_namespace", "object", "groupkind"},
-			)
-			objectProcessErrors := prometheus.NewCounterVec(
-				prometheus.CounterOpts{
-					Namespace: metricsNamespace,
-					Name:      "process_object_errors_total",
-					Help:      "Records the number of times an error was triggered while processing an object",
-				},
-				[]string{"controller", "object_namespace", "object", "groupkind", "external", "retriable"},
-			)
+			controllerWorkers := descr.Workers
+			if controllerWorkers == 0 {
+				controllerWorkers = workers
+			}
+			processTimeout := descr.ProcessTimeout
+			if processTimeout == 0 {
+				processTimeout = config.ProcessTimeout
+			}
 
 			holders[descr.Gvk] = Holder{
-				AppName:             config.AppName,
-				Cntrlr:              constructed.Interface,
-				ReadyForWork:        readyForWork,
-				objectProcessTime:   objectProcessTime,
-				objectProcessErrors: objectProcessErrors,
+				AppName:                config.AppName,
+				Cntrlr:                 constructed.Interface,
+				ReadyForWork:           readyForWork,
+				logger:                 controllerLogger,
+				queue:                  wq,
+				workers:                controllerWorkers,
+				retryPolicy:            policy,
+				processTimeout:         processTimeout,
+				recoverPanics:          config.RecoverPanics,
+				recorder:               recorder,
+				emitDropEvents:         config.EmitDropEvents,
+				deletedObjects:         deleted,
+				finalizer:              fin,
+				separateLeaderElection: descr.SeparateLeaderElection,
+				state:                  &controllerState{},
+				health:                 health,
+				metricsCardinality:     metricsCardinality,
+				objectProcessTime:      objectProcessTime,
+				objectProcessErrors:    objectProcessErrors,
+				objectProcessPanics:    objectProcessPanics,
+				eventProcessLatency:    eventProcessLatency,
 			}
-
-			allMetrics = append(allMetrics, objectProcessTime, objectProcessErrors)
 		}
 
 		if constructed.Server != nil {
@@ -145,36 +351,136 @@ func NewGeneric(config *ctrl.Config, queue workqueue.RateLimitingInterface, work
 				ReadyForWork: readyForWork,
 				requestTime:  requestTime,
 			}
-
-			allMetrics = append(allMetrics, requestTime)
 		}
+	}
 
-		for _, metric := range allMetrics {
-			if err := constructorConfig.Registry.Register(metric); err != nil {
-				return nil, errors.WithStack(err)
-			}
+	for gvk, selector := range informerSelectors {
+		config.Logger.Info("Informer is restricted by selector",
+			logz.ObjectGk(gvk.GroupKind()),
+			zap.String("label_selector", selector.LabelSelector),
+			zap.String("field_selector", selector.FieldSelector))
+		informerSelectorInfo.WithLabelValues(config.AppName, gvk.GroupKind().String(), selector.LabelSelector, selector.FieldSelector).Set(1)
+	}
+
+	allMetrics = append(allMetrics, newFinalizerCollector(holders), newLeadershipCollector(holders), newWorkQueueCollector(holders), healthChecks)
+	for _, metric := range allMetrics {
+		if err := config.Registry.Register(metric); err != nil {
+			return nil, errors.WithStack(err)
 		}
 	}
 
-	return &Generic{
+	g := &Generic{
 		logger:      config.Logger,
-		queue:       wq,
-		workers:     workers,
 		Controllers: holders,
 		Servers:     serverHolders,
 		Informers:   informers,
-	}, nil
+
+		ClusterInformers: clusterInformers,
+		clusters:         clusters,
+		healthConfig: HealthConfig{
+			StuckThreshold:       config.LivenessStuckThreshold,
+			InformerEventTimeout: config.LivenessInformerEventTimeout,
+		},
+		HealthChecks: healthChecks,
+		slowObjects:  newSlowObjects(config.SlowObjects),
+		tracer:       tracing.Tracer(config.TracerProvider),
+		logLevels:    config.LogLevels,
+	}
+	if err := healthChecks.AddCheck(controllersLiveCheck, healthz.Liveness, g.checkLive); err != nil {
+		return nil, err
+	}
+	if err := healthChecks.AddCheck(controllersReadyCheck, healthz.Readiness, g.checkReady); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// finalizerResource returns the resource of gvk that is used to update finalizers. The resource is discovered
+// using the REST mapper if it is not specified.
+func finalizerResource(config *ctrl.Config, gvk schema.GroupVersionKind, resource string) (schema.GroupVersionResource, error) {
+	if resource != "" {
+		return gvk.GroupVersion().WithResource(resource), nil
+	}
+	if config.RESTMapper == nil {
+		return schema.GroupVersionResource{}, errors.Errorf("finalizer for GVK %s must have a resource or a REST mapper must be configured", gvk)
+	}
+	mapping, err := config.RESTMapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return schema.GroupVersionResource{}, errors.Wrapf(err, "failed to find resource of finalizer for GVK %s", gvk)
+	}
+	return mapping.Resource, nil
+}
+
+// newRecorder constructs an event recorder for a controller. The recorder's scheme has all built-in Kubernetes
+// types and types of the controller's GVK registered.
+func newRecorder(config *ctrl.Config, descr ctrl.Descriptor) (record.EventRecorder, error) {
+	if config.EventBroadcaster == nil {
+		return noopRecorder{}, nil
+	}
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if descr.AddToScheme != nil {
+		if err := descr.AddToScheme(scheme); err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+	return config.EventBroadcaster.NewRecorder(scheme, core_v1.EventSource{Component: config.AppName}), nil
+}
+
+// noopRecorder is an event recorder that discards all events.
+type noopRecorder struct{}
+
+func (noopRecorder) Event(object runtime.Object, eventtype, reason, message string) {}
+
+func (noopRecorder) Eventf(object runtime.Object, eventtype, reason, messageFmt string, args ...interface{}) {
+}
+
+func (noopRecorder) PastEventf(object runtime.Object, timestamp meta_v1.Time, eventtype, reason, messageFmt string, args ...interface{}) {
+}
+
+func (noopRecorder) AnnotatedEventf(object runtime.Object, annotations map[string]string, eventtype, reason, messageFmt string, args ...interface{}) {
 }
 
 func (g *Generic) Run(ctx context.Context) error {
 	// Stager will perform ordered, graceful shutdown
 	stgr := stager.New()
 	defer stgr.Shutdown()
-	defer g.queue.shutDown()
 
-	// Stage: start all informers then wait on them
+	if err := g.startInformers(ctx, stgr.NextStage()); err != nil {
+		return err
+	}
+	if err := g.startControllers(ctx, stgr.NextStage(), g.Controllers); err != nil {
+		return err
+	}
+
+	// Stage: start workers. Each controller has its own pool of workers that is shut down independently.
 	stage := stgr.NextStage()
+	for _, c := range g.Controllers {
+		c := c // capture field into a scoped variable to avoid data race
+		c.state.setLeading(true)
+		stage.StartWithContext(func(ctx context.Context) {
+			defer logz.LogStructuredPanic()
+			g.runWorkers(ctx, c)
+		})
+	}
+	return g.runServers(ctx)
+}
+
+// startInformers starts all informers, including informers for additional clusters, in the stage
+// then waits for them to sync.
+func (g *Generic) startInformers(ctx context.Context, stage stager.Stage) error {
+	allInformers := make([]cache.SharedIndexInformer, 0, len(g.Informers))
 	for _, inf := range g.Informers {
+		allInformers = append(allInformers, inf)
+	}
+	for _, clusterInfs := range g.ClusterInformers {
+		for _, inf := range clusterInfs {
+			allInformers = append(allInformers, inf)
+		}
+	}
+	for _, inf := range allInformers {
 		inf := inf // capture field into a scoped variable to avoid data race
 		stage.StartWithChannel(func(stopCh <-chan struct{}) {
 			defer logz.LogStructuredPanic()
@@ -182,23 +488,34 @@ func (g *Generic) Run(ctx context.Context) error {
 		})
 	}
 	g.logger.Info("Waiting for informers to sync")
-	for _, inf := range g.Informers {
+	for _, inf := range allInformers {
 		if !cache.WaitForCacheSync(ctx.Done(), inf.HasSynced) {
 			return ctx.Err()
 		}
 	}
 	g.logger.Info("Informers synced")
+	now := time.Now()
+	for _, holder := range g.Controllers {
+		holder.health.informersSynced(now)
+	}
+	atomic.StoreInt32(&g.synced, 1)
+	return nil
+}
 
-	// Stage: start all controllers then wait for them to signal ready for work
-	stage = stgr.NextStage()
-	for _, c := range g.Controllers {
+// startControllers starts the controllers that have not been started yet in the stage
+// then waits for them to signal ready for work.
+func (g *Generic) startControllers(ctx context.Context, stage stager.Stage, controllers map[schema.GroupVersionKind]Holder) error {
+	for _, c := range controllers {
+		if !atomic.CompareAndSwapInt32(&c.state.started, 0, 1) {
+			continue
+		}
 		c := c // capture field into a scoped variable to avoid data race
 		stage.StartWithContext(func(ctx context.Context) {
 			defer logz.LogStructuredPanic()
 			c.Cntrlr.Run(ctx)
 		})
 	}
-	for gvk, c := range g.Controllers {
+	for gvk, c := range controllers {
 		select {
 		case <-ctx.Done():
 			g.logger.Sugar().Infof("Was waiting for the controller for %s to become ready for processing", gvk)
@@ -206,16 +523,57 @@ func (g *Generic) Run(ctx context.Context) error {
 		case <-c.ReadyForWork:
 		}
 	}
+	return nil
+}
 
-	// Stage: start workers
-	stage = stgr.NextStage()
-	for i := uint(0); i < g.workers; i++ {
-		stage.Start(func() {
-			defer logz.LogStructuredPanic()
-			g.worker()
+// enqueueAll adds all objects from the controller's informers in all clusters and all deleted objects
+// whose deletion has not been processed yet to the controller's work queue.
+func (g *Generic) enqueueAll(gvk schema.GroupVersionKind, holder Holder) {
+	g.enqueueAllFromInformer("", g.Informers[gvk], gvk, holder)
+	for cluster, clusterInfs := range g.ClusterInformers {
+		if inf, ok := clusterInfs[gvk]; ok {
+			g.enqueueAllFromInformer(cluster, inf, gvk, holder)
+		}
+	}
+	for _, key := range holder.deletedObjects.keys() {
+		holder.queue.add(gvkQueueKey{
+			gvk:      gvk,
+			QueueKey: key,
+		})
+	}
+}
+
+func (g *Generic) enqueueAllFromInformer(cluster string, inf cache.SharedIndexInformer, gvk schema.GroupVersionKind, holder Holder) {
+	for _, key := range inf.GetStore().ListKeys() {
+		namespace, name, err := cache.SplitMetaNamespaceKey(key)
+		if err != nil {
+			g.logger.Error("Failed to split object key", zap.String("key", key), zap.Error(err))
+			continue
+		}
+		holder.queue.add(gvkQueueKey{
+			gvk: gvk,
+			QueueKey: ctrl.QueueKey{
+				Cluster:   cluster,
+				Namespace: namespace,
+				Name:      name,
+			},
 		})
 	}
+}
+
+// informerFor returns the informer for the key's GVK in the key's cluster.
+func (g *Generic) informerFor(key gvkQueueKey) (cache.SharedIndexInformer, error) {
+	if key.Cluster == "" {
+		return g.Informers[key.gvk], nil
+	}
+	inf, ok := g.ClusterInformers[key.Cluster][key.gvk]
+	if !ok {
+		return nil, errors.Errorf("no informer for GVK %s in cluster %q", key.gvk, key.Cluster)
+	}
+	return inf, nil
+}
 
+func (g *Generic) runServers(ctx context.Context) error {
 	if len(g.Servers) == 0 {
 		<-ctx.Done()
 		return ctx.Err()
@@ -232,24 +590,50 @@ func (g *Generic) Run(ctx context.Context) error {
 	return group.Wait()
 }
 
+// IsReady returns true once informers have synced and all controllers and servers are ready for work.
+// If leader election is used, controllers that have not been started because this replica
+// is not leading them are not required to be ready. Servers run on all replicas and are always required to be ready.
 func (g *Generic) IsReady() bool {
+	if atomic.LoadInt32(&g.synced) == 0 {
+		return false
+	}
 	for _, holder := range g.Controllers {
-		select {
-		case <-holder.ReadyForWork:
-		default:
+		if !isReadyForWork(holder.ReadyForWork) && !g.isStandby(holder) {
 			return false
 		}
 	}
 	for _, holder := range g.Servers {
-		select {
-		case <-holder.ReadyForWork:
-		default:
+		if !isReadyForWork(holder.ReadyForWork) {
 			return false
 		}
 	}
 	return true
 }
 
+// isStandby returns true if the controller has not been started because this replica is not leading it.
+func (g *Generic) isStandby(holder Holder) bool {
+	return atomic.LoadInt32(&g.electing) != 0 && !holder.state.isStarted()
+}
+
+func isReadyForWork(readyForWork <-chan struct{}) bool {
+	select {
+	case <-readyForWork:
+		return true
+	default:
+		return false
+	}
+}
+
+// chainMiddleware returns a middleware that applies the middlewares in order, the first one being the outermost.
+func chainMiddleware(middlewares ...func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		for i := len(middlewares) - 1; i >= 0; i-- {
+			next = middlewares[i](next)
+		}
+		return next
+	}
+}
+
 func addMetricsMiddleware(requestTime *prometheus.HistogramVec, controller, groupKind string) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -258,17 +642,35 @@ func addMetricsMiddleware(requestTime *prometheus.HistogramVec, controller, grou
 			next.ServeHTTP(res, r)
 			tn := time.Since(t0)
 
-			requestTime.WithLabelValues(r.URL.Path, r.Method, string(res.Status()), controller, groupKind).Observe(tn.Seconds())
+			requestTime.WithLabelValues(r.URL.Path, r.Method, strconv.Itoa(res.Status()), controller, groupKind).Observe(tn.Seconds())
 		})
 	}
 }
 
 type Holder struct {
-	AppName             string
-	Cntrlr              ctrl.Interface
-	ReadyForWork        <-chan struct{}
+	AppName        string
+	Cntrlr         ctrl.Interface
+	ReadyForWork   <-chan struct{}
+	logger         *zap.Logger
+	queue          *workQueue
+	workers        uint
+	retryPolicy    ctrl.RetryPolicy
+	processTimeout time.Duration
+	recoverPanics  bool
+	recorder       record.EventRecorder
+	emitDropEvents bool
+	deletedObjects *deletedObjects
+	finalizer      *finalizer
+	// separateLeaderElection is true if the controller contends for leadership using its own leader election lock.
+	separateLeaderElection bool
+	state                  *controllerState
+	health                 *controllerHealth
+	// metricsCardinality controls how objects are identified in per-object metrics.
+	metricsCardinality  ctrl.MetricsCardinality
 	objectProcessTime   *prometheus.HistogramVec
 	objectProcessErrors *prometheus.CounterVec
+	objectProcessPanics *prometheus.CounterVec
+	eventProcessLatency *prometheus.HistogramVec
 }
 
 type ServerHolder struct {
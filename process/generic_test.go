@@ -0,0 +1,1096 @@
+package process
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/atlassian/ctrl"
+	"github.com/atlassian/ctrl/loglevel"
+	"github.com/atlassian/ctrl/tracing"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest"
+	core_v1 "k8s.io/api/core/v1"
+	api_errors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	core_v1inf "k8s.io/client-go/informers/core/v1"
+	"k8s.io/client-go/informers/internalinterfaces"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	metadatafake "k8s.io/client-go/metadata/fake"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+)
+
+var (
+	configMapGvk = core_v1.SchemeGroupVersion.WithKind("ConfigMap")
+	secretGvk    = core_v1.SchemeGroupVersion.WithKind("Secret")
+)
+
+type fakeController struct {
+	process func(*ctrl.ProcessContext) (ctrl.ProcessResult, error)
+}
+
+func (c *fakeController) Run(ctx context.Context) {
+	<-ctx.Done()
+}
+
+func (c *fakeController) Process(pctx *ctrl.ProcessContext) (ctrl.ProcessResult, error) {
+	return c.process(pctx)
+}
+
+type fakeDeletionController struct {
+	fakeController
+	processDeleted func(*ctrl.ProcessContext, ctrl.QueueKey) (ctrl.ProcessResult, error)
+}
+
+func (c *fakeDeletionController) ProcessDeleted(pctx *ctrl.ProcessContext, key ctrl.QueueKey) (ctrl.ProcessResult, error) {
+	return c.processDeleted(pctx, key)
+}
+
+type fakeConstructor struct {
+	descr       ctrl.Descriptor
+	newInformer func(kubernetes.Interface, string, time.Duration, cache.Indexers) cache.SharedIndexInformer
+	// newFilteredInformer is used instead of newInformer if set.
+	newFilteredInformer func(kubernetes.Interface, string, time.Duration, cache.Indexers, internalinterfaces.TweakListOptionsFunc) cache.SharedIndexInformer
+	// dynamic makes the constructor use a dynamic informer instead of newInformer if set.
+	dynamic bool
+	// metadataResource makes the constructor use a metadata informer for the resource instead of newInformer if set.
+	metadataResource string
+	// multiCluster makes the constructor watch all clusters using newInformer if set.
+	multiCluster   bool
+	server         ctrl.Server
+	process        func(*ctrl.ProcessContext) (ctrl.ProcessResult, error)
+	processDeleted func(*ctrl.ProcessContext, ctrl.QueueKey) (ctrl.ProcessResult, error)
+	// config is set to the config the constructor was called with.
+	config *ctrl.Config
+}
+
+func (c *fakeConstructor) AddFlags(ctrl.FlagSet) {}
+
+func (c *fakeConstructor) New(config *ctrl.Config, cctx *ctrl.Context) (*ctrl.Constructed, error) {
+	c.config = config
+	if c.dynamic {
+		if _, err := cctx.MainDynamicInformer(config, c.descr.Gvk); err != nil {
+			return nil, err
+		}
+	} else if c.metadataResource != "" {
+		if _, err := cctx.MainMetadataInformer(config, c.descr.Gvk, c.metadataResource); err != nil {
+			return nil, err
+		}
+	} else if c.multiCluster {
+		if _, err := cctx.MultiClusterInformer(config, c.descr.Gvk, c.newInformer); err != nil {
+			return nil, err
+		}
+	} else if c.newFilteredInformer != nil {
+		if _, err := cctx.MainFilteredInformer(config, c.descr.Gvk, c.newFilteredInformer); err != nil {
+			return nil, err
+		}
+	} else if _, err := cctx.MainInformer(config, c.descr.Gvk, c.newInformer); err != nil {
+		return nil, err
+	}
+	var cntrlr ctrl.Interface = &fakeController{
+		process: c.process,
+	}
+	if c.processDeleted != nil {
+		cntrlr = &fakeDeletionController{
+			fakeController: fakeController{
+				process: c.process,
+			},
+			processDeleted: c.processDeleted,
+		}
+	}
+	go cctx.ReadyForWork()
+	return &ctrl.Constructed{
+		Interface: cntrlr,
+		Server:    c.server,
+	}, nil
+}
+
+func (c *fakeConstructor) Describe() ctrl.Descriptor {
+	return c.descr
+}
+
+// fakeServer sends its name to started when it is run.
+type fakeServer struct {
+	name    string
+	started chan<- string
+}
+
+func (s *fakeServer) Run(ctx context.Context) error {
+	s.started <- s.name
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func testConfig(t *testing.T, objects ...runtime.Object) *ctrl.Config {
+	return &ctrl.Config{
+		AppName:      "test",
+		Logger:       zaptest.NewLogger(t),
+		Namespace:    meta_v1.NamespaceAll,
+		ResyncPeriod: time.Hour,
+		Registry:     prometheus.NewPedanticRegistry(),
+		MainClient:   fake.NewSimpleClientset(objects...),
+	}
+}
+
+func TestGenericControllersHaveIndependentQueues(t *testing.T) {
+	t.Parallel()
+
+	config := testConfig(t,
+		&core_v1.ConfigMap{ObjectMeta: meta_v1.ObjectMeta{Namespace: "ns", Name: "slow"}},
+		&core_v1.Secret{ObjectMeta: meta_v1.ObjectMeta{Namespace: "ns", Name: "fast"}},
+	)
+
+	unblockSlow := make(chan struct{})
+	slowStarted := make(chan struct{})
+	fastProcessed := make(chan schema.GroupVersionKind, 1)
+
+	generic, err := NewGeneric(config, 1,
+		&fakeConstructor{
+			descr:       ctrl.Descriptor{Gvk: configMapGvk},
+			newInformer: core_v1inf.NewConfigMapInformer,
+			process: func(pctx *ctrl.ProcessContext) (ctrl.ProcessResult, error) {
+				close(slowStarted)
+				<-unblockSlow
+				return ctrl.ProcessResult{}, nil
+			},
+		},
+		&fakeConstructor{
+			descr:       ctrl.Descriptor{Gvk: secretGvk, Workers: 3},
+			newInformer: core_v1inf.NewSecretInformer,
+			process: func(pctx *ctrl.ProcessContext) (ctrl.ProcessResult, error) {
+				fastProcessed <- pctx.Object.GetObjectKind().GroupVersionKind()
+				return ctrl.ProcessResult{}, nil
+			},
+		},
+	)
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, generic.Controllers[configMapGvk].workers)
+	assert.EqualValues(t, 3, generic.Controllers[secretGvk].workers)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- generic.Run(ctx)
+	}()
+
+	select {
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for the slow controller to start processing")
+	case <-slowStarted:
+	}
+	// The only worker of the slow controller is blocked but the other controller is not affected
+	select {
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for the fast controller to process an object")
+	case gvk := <-fastProcessed:
+		assert.Equal(t, secretGvk, gvk)
+	}
+
+	close(unblockSlow)
+	cancel()
+	assert.Equal(t, context.Canceled, <-runErr)
+}
+
+func TestGenericControllersGetOwnLoggers(t *testing.T) {
+	t.Parallel()
+
+	config := testConfig(t)
+	logger := config.Logger
+	config.LogLevels = loglevel.NewRegistry(zapcore.InfoLevel)
+
+	configMapConstr := &fakeConstructor{
+		descr:       ctrl.Descriptor{Gvk: configMapGvk},
+		newInformer: core_v1inf.NewConfigMapInformer,
+	}
+	secretConstr := &fakeConstructor{
+		descr:       ctrl.Descriptor{Gvk: secretGvk},
+		newInformer: core_v1inf.NewSecretInformer,
+	}
+	generic, err := NewGeneric(config, 1, configMapConstr, secretConstr)
+	require.NoError(t, err)
+
+	// Caller's config is not modified
+	assert.Same(t, logger, config.Logger)
+	assert.Same(t, logger, generic.logger)
+	require.NotNil(t, configMapConstr.config)
+	require.NotNil(t, secretConstr.config)
+	assert.NotSame(t, config, configMapConstr.config)
+	assert.NotSame(t, configMapConstr.config, secretConstr.config)
+
+	require.NoError(t, config.LogLevels.SetControllerLevel(configMapGvk, zapcore.DebugLevel))
+	assert.True(t, configMapConstr.config.Logger.Core().Enabled(zapcore.DebugLevel))
+	assert.False(t, secretConstr.config.Logger.Core().Enabled(zapcore.DebugLevel))
+	assert.True(t, generic.Controllers[configMapGvk].logger.Core().Enabled(zapcore.DebugLevel))
+	assert.False(t, generic.Controllers[secretGvk].logger.Core().Enabled(zapcore.DebugLevel))
+}
+
+func TestGenericRequeueAfterSuccessfulProcessing(t *testing.T) {
+	t.Parallel()
+
+	config := testConfig(t,
+		&core_v1.ConfigMap{ObjectMeta: meta_v1.ObjectMeta{Namespace: "ns", Name: "cm"}},
+	)
+
+	var calls int
+	processedTwice := make(chan struct{})
+	generic, err := NewGeneric(config, 1,
+		&fakeConstructor{
+			descr:       ctrl.Descriptor{Gvk: configMapGvk},
+			newInformer: core_v1inf.NewConfigMapInformer,
+			process: func(pctx *ctrl.ProcessContext) (ctrl.ProcessResult, error) {
+				calls++
+				if calls == 2 {
+					close(processedTwice)
+				}
+				return ctrl.ProcessResult{RequeueAfter: 10 * time.Millisecond}, nil
+			},
+		},
+	)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- generic.Run(ctx)
+	}()
+
+	select {
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for the object to be requeued")
+	case <-processedTwice:
+	}
+	cancel()
+	assert.Equal(t, context.Canceled, <-runErr)
+}
+
+func TestGenericProcessDeletedGetsLastKnownState(t *testing.T) {
+	t.Parallel()
+
+	config := testConfig(t,
+		&core_v1.ConfigMap{
+			ObjectMeta: meta_v1.ObjectMeta{Namespace: "ns", Name: "cm"},
+			Data:       map[string]string{"a": "b"},
+		},
+	)
+
+	processed := make(chan struct{})
+	deleted := make(chan *ctrl.ProcessContext, 1)
+	generic, err := NewGeneric(config, 1,
+		&fakeConstructor{
+			descr:       ctrl.Descriptor{Gvk: configMapGvk},
+			newInformer: core_v1inf.NewConfigMapInformer,
+			process: func(pctx *ctrl.ProcessContext) (ctrl.ProcessResult, error) {
+				close(processed)
+				return ctrl.ProcessResult{}, nil
+			},
+			processDeleted: func(pctx *ctrl.ProcessContext, key ctrl.QueueKey) (ctrl.ProcessResult, error) {
+				assert.Equal(t, ctrl.QueueKey{Namespace: "ns", Name: "cm"}, key)
+				deleted <- pctx
+				return ctrl.ProcessResult{}, nil
+			},
+		},
+	)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- generic.Run(ctx)
+	}()
+
+	select {
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for the object to be processed")
+	case <-processed:
+	}
+	err = config.MainClient.CoreV1().ConfigMaps("ns").Delete("cm", nil)
+	require.NoError(t, err)
+
+	select {
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for the deletion to be processed")
+	case pctx := <-deleted:
+		require.NotNil(t, pctx.Object)
+		assert.Equal(t, configMapGvk, pctx.Object.GetObjectKind().GroupVersionKind())
+		assert.Equal(t, map[string]string{"a": "b"}, pctx.Object.(*core_v1.ConfigMap).Data)
+	}
+	cancel()
+	assert.Equal(t, context.Canceled, <-runErr)
+	assert.Empty(t, generic.Controllers[configMapGvk].deletedObjects.objects)
+}
+
+func TestGenericWarmStandbyReprocessesOnReacquiringLeadership(t *testing.T) {
+	t.Parallel()
+
+	config := testConfig(t,
+		&core_v1.ConfigMap{ObjectMeta: meta_v1.ObjectMeta{Namespace: "ns", Name: "cm"}},
+	)
+
+	processed := make(chan struct{})
+	generic, err := NewGeneric(config, 1,
+		&fakeConstructor{
+			descr:       ctrl.Descriptor{Gvk: configMapGvk},
+			newInformer: core_v1inf.NewConfigMapInformer,
+			process: func(pctx *ctrl.ProcessContext) (ctrl.ProcessResult, error) {
+				processed <- struct{}{}
+				return ctrl.ProcessResult{}, nil
+			},
+		},
+	)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	grant := make(chan context.CancelFunc)
+	released := make(chan struct{}, 2)
+	elect := func(ctx context.Context) (context.Context, func(), error) {
+		leaderCtx, lose := context.WithCancel(ctx)
+		select {
+		case <-ctx.Done():
+			lose()
+			return nil, nil, ctx.Err()
+		case grant <- lose:
+		}
+		return leaderCtx, func() {
+			released <- struct{}{}
+		}, nil
+	}
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- generic.RunWithLeaderElection(ctx, elect, nil, true)
+	}()
+
+	for i := 0; i < 2; i++ {
+		var lose context.CancelFunc
+		select {
+		case <-ctx.Done():
+			t.Fatal("timed out waiting for leader election")
+		case lose = <-grant:
+		}
+		select {
+		case <-ctx.Done():
+			t.Fatal("timed out waiting for the object to be processed")
+		case <-processed:
+		}
+		lose()
+		select {
+		case <-ctx.Done():
+			t.Fatal("timed out waiting for leadership to be released")
+		case <-released:
+		}
+	}
+	cancel()
+	assert.Equal(t, context.Canceled, <-runErr)
+}
+
+func TestGenericSeparateLeaderElection(t *testing.T) {
+	t.Parallel()
+
+	config := testConfig(t,
+		&core_v1.ConfigMap{ObjectMeta: meta_v1.ObjectMeta{Namespace: "ns", Name: "cm"}},
+		&core_v1.Secret{ObjectMeta: meta_v1.ObjectMeta{Namespace: "ns", Name: "secret"}},
+	)
+
+	processed := make(chan schema.GroupVersionKind, 1)
+	process := func(pctx *ctrl.ProcessContext) (ctrl.ProcessResult, error) {
+		processed <- pctx.Object.GetObjectKind().GroupVersionKind()
+		return ctrl.ProcessResult{}, nil
+	}
+	serverStarted := make(chan string, 2)
+	generic, err := NewGeneric(config, 1,
+		&fakeConstructor{
+			descr:       ctrl.Descriptor{Gvk: configMapGvk},
+			newInformer: core_v1inf.NewConfigMapInformer,
+			server:      &fakeServer{name: "configmap", started: serverStarted},
+			process:     process,
+		},
+		&fakeConstructor{
+			descr:       ctrl.Descriptor{Gvk: secretGvk, SeparateLeaderElection: true},
+			newInformer: core_v1inf.NewSecretInformer,
+			server:      &fakeServer{name: "secret", started: serverStarted},
+			process:     process,
+		},
+	)
+	require.NoError(t, err)
+	assert.True(t, generic.HasSeparateLeaderElection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// Another replica leads the shared lock
+	neverElected := func(ctx context.Context) (context.Context, func(), error) {
+		<-ctx.Done()
+		return nil, nil, ctx.Err()
+	}
+	elected := func(ctx context.Context) (context.Context, func(), error) {
+		return ctx, func() {}, nil
+	}
+	var electedGvk schema.GroupVersionKind
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- generic.RunWithLeaderElection(ctx, neverElected, func(gvk schema.GroupVersionKind) Elector {
+			electedGvk = gvk
+			return elected
+		}, false)
+	}()
+
+	select {
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for the object to be processed")
+	case gvk := <-processed:
+		assert.Equal(t, secretGvk, gvk)
+	}
+	// Servers run regardless of leadership
+	var started []string
+	for i := 0; i < 2; i++ {
+		select {
+		case <-ctx.Done():
+			t.Fatal("timed out waiting for servers to be started")
+		case name := <-serverStarted:
+			started = append(started, name)
+		}
+	}
+	assert.ElementsMatch(t, []string{"configmap", "secret"}, started)
+	assert.Equal(t, secretGvk, electedGvk)
+	assert.Equal(t, map[schema.GroupVersionKind]bool{
+		configMapGvk: false,
+		secretGvk:    true,
+	}, generic.Leadership())
+	// Controller that is not led by this replica is not started but the replica is ready
+	assert.True(t, generic.IsReady())
+
+	cancel()
+	assert.Equal(t, context.Canceled, <-runErr)
+}
+
+func TestGenericInformerSelectors(t *testing.T) {
+	t.Parallel()
+
+	config := testConfig(t,
+		&core_v1.ConfigMap{ObjectMeta: meta_v1.ObjectMeta{Namespace: "ns", Name: "selected", Labels: map[string]string{"app": "a", "tier": "web"}}},
+		&core_v1.ConfigMap{ObjectMeta: meta_v1.ObjectMeta{Namespace: "ns", Name: "other-tier", Labels: map[string]string{"app": "a", "tier": "db"}}},
+		&core_v1.ConfigMap{ObjectMeta: meta_v1.ObjectMeta{Namespace: "ns", Name: "other-app", Labels: map[string]string{"app": "b", "tier": "web"}}},
+	)
+	config.InformerSelector = ctrl.Selector{LabelSelector: "app=a"}
+
+	_, err := NewGeneric(config, 1, &fakeConstructor{
+		descr:       ctrl.Descriptor{Gvk: configMapGvk, Selector: ctrl.Selector{LabelSelector: "tier=web"}},
+		newInformer: core_v1inf.NewConfigMapInformer,
+	})
+	require.Error(t, err, "selector cannot be applied by MainInformer")
+
+	config.Registry = prometheus.NewPedanticRegistry()
+	_, err = NewGeneric(config, 1, &fakeConstructor{
+		descr:       ctrl.Descriptor{Gvk: configMapGvk},
+		newInformer: core_v1inf.NewConfigMapInformer,
+	})
+	require.Error(t, err, "informer selector cannot be applied by MainInformer")
+
+	config.Registry = prometheus.NewPedanticRegistry()
+	processed := make(chan string, 3)
+	generic, err := NewGeneric(config, 1, &fakeConstructor{
+		descr:               ctrl.Descriptor{Gvk: configMapGvk, Selector: ctrl.Selector{LabelSelector: "tier=web"}},
+		newFilteredInformer: core_v1inf.NewFilteredConfigMapInformer,
+		process: func(pctx *ctrl.ProcessContext) (ctrl.ProcessResult, error) {
+			processed <- pctx.Object.(*core_v1.ConfigMap).Name
+			return ctrl.ProcessResult{}, nil
+		},
+	})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- generic.Run(ctx)
+	}()
+
+	select {
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for the object to be processed")
+	case name := <-processed:
+		assert.Equal(t, "selected", name)
+	}
+	assert.Equal(t, []string{"ns/selected"}, generic.Informers[configMapGvk].GetStore().ListKeys())
+	cancel()
+	assert.Equal(t, context.Canceled, <-runErr)
+}
+
+func TestGenericDynamicController(t *testing.T) {
+	t.Parallel()
+
+	gvk := schema.GroupVersionKind{Group: "example.com", Version: "v1", Kind: "Widget"}
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(gvk)
+	obj.SetNamespace("ns")
+	obj.SetName("widget")
+
+	restMapper := meta.NewDefaultRESTMapper(nil)
+	restMapper.Add(gvk, meta.RESTScopeNamespace)
+	config := testConfig(t)
+	config.DynamicClient = dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), obj)
+	config.RESTMapper = restMapper
+
+	processed := make(chan *unstructured.Unstructured, 1)
+	generic, err := NewGeneric(config, 1, &fakeConstructor{
+		descr:   ctrl.Descriptor{Gvk: gvk},
+		dynamic: true,
+		process: func(pctx *ctrl.ProcessContext) (ctrl.ProcessResult, error) {
+			processed <- pctx.Object.(*unstructured.Unstructured)
+			return ctrl.ProcessResult{}, nil
+		},
+	})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- generic.Run(ctx)
+	}()
+
+	select {
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for the object to be processed")
+	case u := <-processed:
+		assert.Equal(t, gvk, u.GroupVersionKind())
+		assert.Equal(t, "widget", u.GetName())
+	}
+	cancel()
+	assert.Equal(t, context.Canceled, <-runErr)
+}
+
+func TestGenericMetadataController(t *testing.T) {
+	t.Parallel()
+
+	scheme := runtime.NewScheme()
+	require.NoError(t, meta_v1.AddMetaToScheme(scheme))
+	config := testConfig(t)
+	config.MetadataClient = metadatafake.NewSimpleMetadataClient(scheme, &meta_v1.PartialObjectMetadata{
+		TypeMeta: meta_v1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"},
+		ObjectMeta: meta_v1.ObjectMeta{
+			Namespace:     "ns",
+			Name:          "cm",
+			Annotations:   map[string]string{"large": "x", "small": "y"},
+			ManagedFields: []meta_v1.ManagedFieldsEntry{{Manager: "m"}},
+		},
+	})
+	config.InformerTransform = ctrl.ChainTransforms(ctrl.StripManagedFields, ctrl.StripAnnotations("large"))
+
+	processed := make(chan *meta_v1.PartialObjectMetadata, 1)
+	generic, err := NewGeneric(config, 1, &fakeConstructor{
+		descr:            ctrl.Descriptor{Gvk: configMapGvk},
+		metadataResource: "configmaps",
+		process: func(pctx *ctrl.ProcessContext) (ctrl.ProcessResult, error) {
+			processed <- pctx.Object.(*meta_v1.PartialObjectMetadata)
+			return ctrl.ProcessResult{}, nil
+		},
+	})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- generic.Run(ctx)
+	}()
+
+	select {
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for the object to be processed")
+	case obj := <-processed:
+		assert.Equal(t, configMapGvk, obj.GroupVersionKind())
+		assert.Equal(t, "cm", obj.Name)
+		assert.Equal(t, map[string]string{"small": "y"}, obj.Annotations)
+		assert.Empty(t, obj.ManagedFields)
+	}
+	cancel()
+	assert.Equal(t, context.Canceled, <-runErr)
+}
+
+func TestGenericMultiClusterController(t *testing.T) {
+	t.Parallel()
+
+	config := testConfig(t,
+		&core_v1.ConfigMap{ObjectMeta: meta_v1.ObjectMeta{Namespace: "ns", Name: "main"}},
+	)
+	otherClient := fake.NewSimpleClientset(
+		&core_v1.ConfigMap{ObjectMeta: meta_v1.ObjectMeta{Namespace: "ns", Name: "other"}},
+	)
+	config.Clusters = []ctrl.Cluster{
+		{
+			Name:       "other",
+			MainClient: otherClient,
+		},
+	}
+
+	type processed struct {
+		cluster string
+		name    string
+		client  kubernetes.Interface
+	}
+	processedCh := make(chan processed, 2)
+	generic, err := NewGeneric(config, 1, &fakeConstructor{
+		descr:        ctrl.Descriptor{Gvk: configMapGvk},
+		newInformer:  core_v1inf.NewConfigMapInformer,
+		multiCluster: true,
+		process: func(pctx *ctrl.ProcessContext) (ctrl.ProcessResult, error) {
+			processedCh <- processed{
+				cluster: pctx.Cluster.Name,
+				name:    pctx.Object.(*core_v1.ConfigMap).Name,
+				client:  pctx.Cluster.MainClient,
+			}
+			return ctrl.ProcessResult{}, nil
+		},
+	})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- generic.Run(ctx)
+	}()
+
+	byCluster := make(map[string]processed)
+	for len(byCluster) < 2 {
+		select {
+		case <-ctx.Done():
+			t.Fatal("timed out waiting for objects to be processed")
+		case p := <-processedCh:
+			byCluster[p.cluster] = p
+		}
+	}
+	assert.Equal(t, "main", byCluster[""].name)
+	assert.Equal(t, config.MainClient, byCluster[""].client)
+	assert.Equal(t, "other", byCluster["other"].name)
+	assert.Equal(t, otherClient, byCluster["other"].client)
+	cancel()
+	assert.Equal(t, context.Canceled, <-runErr)
+}
+
+func TestGenericRejectsDuplicateClusters(t *testing.T) {
+	t.Parallel()
+
+	config := testConfig(t)
+	config.Clusters = []ctrl.Cluster{
+		{Name: "other", MainClient: fake.NewSimpleClientset()},
+		{Name: "other", MainClient: fake.NewSimpleClientset()},
+	}
+	_, err := NewGeneric(config, 1, &fakeConstructor{
+		descr:        ctrl.Descriptor{Gvk: configMapGvk},
+		newInformer:  core_v1inf.NewConfigMapInformer,
+		multiCluster: true,
+	})
+	require.Error(t, err)
+}
+
+func TestGenericObservesEventToProcessedLatency(t *testing.T) {
+	t.Parallel()
+
+	config := testConfig(t,
+		&core_v1.ConfigMap{ObjectMeta: meta_v1.ObjectMeta{Namespace: "ns", Name: "cm"}},
+	)
+	registry := prometheus.NewPedanticRegistry()
+	config.Registry = registry
+
+	generic, err := NewGeneric(config, 1, &fakeConstructor{
+		descr:       ctrl.Descriptor{Gvk: configMapGvk},
+		newInformer: core_v1inf.NewConfigMapInformer,
+		process: func(pctx *ctrl.ProcessContext) (ctrl.ProcessResult, error) {
+			return ctrl.ProcessResult{}, nil
+		},
+	})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- generic.Run(ctx)
+	}()
+
+	err = wait.PollImmediate(10*time.Millisecond, 5*time.Second, func() (bool, error) {
+		families, err := registry.Gather()
+		if err != nil {
+			return false, err
+		}
+		for _, family := range families {
+			if family.GetName() == "ctrl_event_to_processed_seconds" {
+				return family.GetMetric()[0].GetHistogram().GetSampleCount() == 1, nil
+			}
+		}
+		return false, nil
+	})
+	assert.NoError(t, err)
+	cancel()
+	assert.Equal(t, context.Canceled, <-runErr)
+}
+
+func TestGenericTracesProcessing(t *testing.T) {
+	t.Parallel()
+
+	config := testConfig(t,
+		&core_v1.ConfigMap{ObjectMeta: meta_v1.ObjectMeta{Namespace: "ns", Name: "cm"}},
+	)
+	exporter := tracetest.NewInMemoryExporter()
+	config.TracerProvider = sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	apiServer := httptest.NewServer(http.NotFoundHandler())
+	defer apiServer.Close()
+	config.RestConfig = &rest.Config{
+		Host:          apiServer.URL,
+		WrapTransport: tracing.Transport(config.TracerProvider),
+	}
+
+	processSpans := make(chan trace.SpanContext, 1)
+	generic, err := NewGeneric(config, 1, &fakeConstructor{
+		descr:       ctrl.Descriptor{Gvk: configMapGvk},
+		newInformer: core_v1inf.NewConfigMapInformer,
+		process: func(pctx *ctrl.ProcessContext) (ctrl.ProcessResult, error) {
+			// Client does not accept a context but its request is traced
+			_, err := pctx.Cluster.MainClient.CoreV1().ConfigMaps("ns").Get("other", meta_v1.GetOptions{})
+			assert.True(t, api_errors.IsNotFound(err), err)
+			processSpans <- trace.SpanContextFromContext(pctx.Context)
+			return ctrl.ProcessResult{}, ctrl.NewRetriableError(errors.New("boom"))
+		},
+	})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- generic.Run(ctx)
+	}()
+
+	var processSpan trace.SpanContext
+	select {
+	case processSpan = <-processSpans:
+	case <-ctx.Done():
+		t.Fatal("object was not processed")
+	}
+	err = wait.PollImmediate(10*time.Millisecond, 5*time.Second, func() (bool, error) {
+		return len(exporter.GetSpans()) > 1, nil
+	})
+	require.NoError(t, err)
+	cancel()
+	assert.Equal(t, context.Canceled, <-runErr)
+
+	// Request span ends before the processing span
+	requestSpan := exporter.GetSpans()[0]
+	assert.Equal(t, "HTTP GET", requestSpan.Name)
+	assert.Equal(t, processSpan.SpanID(), requestSpan.Parent.SpanID())
+
+	span := exporter.GetSpans()[1]
+	assert.Equal(t, "process ConfigMap", span.Name)
+	assert.Equal(t, span.SpanContext.SpanID(), processSpan.SpanID())
+	assert.Subset(t, span.Attributes, []attribute.KeyValue{
+		tracing.ControllerKey.String("test"),
+		tracing.GvkKey.String(configMapGvk.String()),
+		tracing.NamespaceKey.String("ns"),
+		tracing.NameKey.String("cm"),
+		tracing.OutcomeKey.String(tracing.OutcomeRetriableError),
+	})
+	assert.Equal(t, codes.Error, span.Status.Code)
+}
+
+// gatherMetrics returns metrics of the family with the name. Returns nil if the registry has no such family.
+func gatherMetrics(t *testing.T, registry prometheus.Gatherer, name string) []*dto.Metric {
+	families, err := registry.Gather()
+	require.NoError(t, err)
+	for _, family := range families {
+		if family.GetName() == name {
+			return family.GetMetric()
+		}
+	}
+	return nil
+}
+
+func labelValue(m *dto.Metric, name string) string {
+	for _, label := range m.GetLabel() {
+		if label.GetName() == name {
+			return label.GetValue()
+		}
+	}
+	return ""
+}
+
+func TestGenericRetriesTimedOutProcessing(t *testing.T) {
+	t.Parallel()
+
+	config := testConfig(t,
+		&core_v1.ConfigMap{ObjectMeta: meta_v1.ObjectMeta{Namespace: "ns", Name: "cm"}},
+	)
+	registry := prometheus.NewPedanticRegistry()
+	config.Registry = registry
+
+	var calls int32
+	processed := make(chan struct{})
+	generic, err := NewGeneric(config, 1, &fakeConstructor{
+		descr: ctrl.Descriptor{
+			Gvk:            configMapGvk,
+			ProcessTimeout: 50 * time.Millisecond,
+		},
+		newInformer: core_v1inf.NewConfigMapInformer,
+		process: func(pctx *ctrl.ProcessContext) (ctrl.ProcessResult, error) {
+			if atomic.AddInt32(&calls, 1) == 1 {
+				<-pctx.Context.Done()
+				return ctrl.ProcessResult{}, pctx.Context.Err()
+			}
+			close(processed)
+			return ctrl.ProcessResult{}, nil
+		},
+	})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- generic.Run(ctx)
+	}()
+
+	select {
+	case <-processed:
+	case <-ctx.Done():
+		t.Fatal("timed out object was not retried")
+	}
+	cancel()
+	assert.Equal(t, context.Canceled, <-runErr)
+
+	errs := gatherMetrics(t, registry, "ctrl_process_object_errors_total")
+	require.Len(t, errs, 1)
+	assert.Equal(t, "true", labelValue(errs[0], "retriable"))
+	assert.Equal(t, "false", labelValue(errs[0], "external"))
+	assert.Equal(t, float64(1), errs[0].GetCounter().GetValue())
+}
+
+func TestGenericDoesNotHandleErrorsOfCancelledProcessing(t *testing.T) {
+	t.Parallel()
+
+	config := testConfig(t,
+		&core_v1.ConfigMap{ObjectMeta: meta_v1.ObjectMeta{Namespace: "ns", Name: "cm"}},
+	)
+	registry := prometheus.NewPedanticRegistry()
+	config.Registry = registry
+	config.EmitDropEvents = true
+
+	processed := make(chan struct{}, 1)
+	deletionStarted := make(chan struct{})
+	deleted := make(chan struct{})
+	var deletedCalls int32
+	generic, err := NewGeneric(config, 1, &fakeConstructor{
+		descr:       ctrl.Descriptor{Gvk: configMapGvk},
+		newInformer: core_v1inf.NewConfigMapInformer,
+		process: func(pctx *ctrl.ProcessContext) (ctrl.ProcessResult, error) {
+			select {
+			case processed <- struct{}{}:
+			default:
+			}
+			return ctrl.ProcessResult{}, nil
+		},
+		processDeleted: func(pctx *ctrl.ProcessContext, key ctrl.QueueKey) (ctrl.ProcessResult, error) {
+			if atomic.AddInt32(&deletedCalls, 1) == 1 {
+				// Processing is cancelled by loss of leadership
+				close(deletionStarted)
+				<-pctx.Context.Done()
+				return ctrl.ProcessResult{}, pctx.Context.Err()
+			}
+			close(deleted)
+			return ctrl.ProcessResult{}, nil
+		},
+	})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	grant := make(chan context.CancelFunc)
+	released := make(chan struct{}, 2)
+	elect := func(ctx context.Context) (context.Context, func(), error) {
+		leaderCtx, lose := context.WithCancel(ctx)
+		select {
+		case <-ctx.Done():
+			lose()
+			return nil, nil, ctx.Err()
+		case grant <- lose:
+		}
+		return leaderCtx, func() {
+			released <- struct{}{}
+		}, nil
+	}
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- generic.RunWithLeaderElection(ctx, elect, nil, true)
+	}()
+
+	var lose context.CancelFunc
+	select {
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for leader election")
+	case lose = <-grant:
+	}
+	select {
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for the object to be processed")
+	case <-processed:
+	}
+	err = config.MainClient.CoreV1().ConfigMaps("ns").Delete("cm", nil)
+	require.NoError(t, err)
+	select {
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for the deletion to be processed")
+	case <-deletionStarted:
+	}
+	lose()
+	select {
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for leadership to be released")
+	case <-released:
+	}
+
+	// Deletion is processed again once leadership is reacquired
+	select {
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for leader election")
+	case <-grant:
+	}
+	select {
+	case <-ctx.Done():
+		t.Fatal("deletion was not processed again after leadership was reacquired")
+	case <-deleted:
+	}
+	cancel()
+	assert.Equal(t, context.Canceled, <-runErr)
+
+	assert.EqualValues(t, 2, atomic.LoadInt32(&deletedCalls))
+	assert.Empty(t, gatherMetrics(t, registry, "ctrl_process_object_errors_total"))
+	assert.Empty(t, generic.Controllers[configMapGvk].deletedObjects.objects)
+}
+
+func TestGenericRetriesObjectAfterRecoveredPanic(t *testing.T) {
+	t.Parallel()
+
+	config := testConfig(t,
+		&core_v1.ConfigMap{ObjectMeta: meta_v1.ObjectMeta{Namespace: "ns", Name: "cm"}},
+	)
+	registry := prometheus.NewPedanticRegistry()
+	config.Registry = registry
+	config.RecoverPanics = true
+
+	var calls int32
+	processed := make(chan struct{})
+	generic, err := NewGeneric(config, 1, &fakeConstructor{
+		descr:       ctrl.Descriptor{Gvk: configMapGvk},
+		newInformer: core_v1inf.NewConfigMapInformer,
+		process: func(pctx *ctrl.ProcessContext) (ctrl.ProcessResult, error) {
+			if atomic.AddInt32(&calls, 1) == 1 {
+				panic("boom")
+			}
+			close(processed)
+			return ctrl.ProcessResult{}, nil
+		},
+	})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- generic.Run(ctx)
+	}()
+
+	select {
+	case <-processed:
+	case <-ctx.Done():
+		t.Fatal("object was not retried after panic")
+	}
+	cancel()
+	assert.Equal(t, context.Canceled, <-runErr)
+
+	panics := gatherMetrics(t, registry, "ctrl_process_object_panics_total")
+	require.Len(t, panics, 1)
+	assert.Equal(t, float64(1), panics[0].GetCounter().GetValue())
+	errs := gatherMetrics(t, registry, "ctrl_process_object_errors_total")
+	require.Len(t, errs, 1)
+	assert.Equal(t, "true", labelValue(errs[0], "retriable"))
+	assert.Equal(t, "false", labelValue(errs[0], "external"))
+}
+
+func TestGenericEmitsEventForDroppedObject(t *testing.T) {
+	t.Parallel()
+
+	config := testConfig(t,
+		&core_v1.ConfigMap{ObjectMeta: meta_v1.ObjectMeta{Namespace: "ns", Name: "cm"}},
+	)
+	broadcaster := record.NewBroadcaster()
+	events := make(chan *core_v1.Event, 1)
+	watcher := broadcaster.StartEventWatcher(func(event *core_v1.Event) {
+		events <- event
+	})
+	defer watcher.Stop()
+	config.EventBroadcaster = broadcaster
+	config.EmitDropEvents = true
+	registry := prometheus.NewPedanticRegistry()
+	config.Registry = registry
+
+	generic, err := NewGeneric(config, 1, &fakeConstructor{
+		descr:       ctrl.Descriptor{Gvk: configMapGvk},
+		newInformer: core_v1inf.NewConfigMapInformer,
+		process: func(pctx *ctrl.ProcessContext) (ctrl.ProcessResult, error) {
+			return ctrl.ProcessResult{}, errors.New("boom")
+		},
+	})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- generic.Run(ctx)
+	}()
+
+	var event *core_v1.Event
+	select {
+	case event = <-events:
+	case <-ctx.Done():
+		t.Fatal("event was not emitted")
+	}
+	cancel()
+	assert.Equal(t, context.Canceled, <-runErr)
+
+	assert.Equal(t, core_v1.EventTypeWarning, event.Type)
+	assert.Equal(t, droppedEventReason, event.Reason)
+	assert.Equal(t, "Processing failed: boom", event.Message)
+	assert.Equal(t, "ConfigMap", event.InvolvedObject.Kind)
+	assert.Equal(t, "ns", event.InvolvedObject.Namespace)
+	assert.Equal(t, "cm", event.InvolvedObject.Name)
+	// Latency is not observed for dropped objects
+	assert.Empty(t, gatherMetrics(t, registry, "ctrl_event_to_processed_seconds"))
+}
@@ -1,73 +1,220 @@
 package process
 
 import (
+	"context"
+	"fmt"
+	"runtime/debug"
 	"strconv"
 	"sync/atomic"
 	"time"
 
 	"github.com/atlassian/ctrl"
+	"github.com/atlassian/ctrl/loglevel"
 	"github.com/atlassian/ctrl/logz"
+	"github.com/atlassian/ctrl/tracing"
 	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
+	core_v1 "k8s.io/api/core/v1"
 	api_errors "k8s.io/apimachinery/pkg/api/errors"
 	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/transport"
 )
 
 const (
-	// maxRetries is the number of times an object will be retried before it is dropped out of the queue.
-	// With the current rate-limiter in use (5ms*2^(maxRetries-1)) the following numbers represent the times
-	// an object is going to be requeued:
-	//
-	// 5ms, 10ms, 20ms, 40ms, 80ms, 160ms, 320ms, 640ms, 1.3s, 2.6s, 5.1s, 10.2s, 20.4s, 41s, 82s
-	maxRetries = 15
+	// droppedEventReason is the reason of events emitted for objects that were dropped out of the work queue.
+	droppedEventReason = "ProcessingFailed"
 )
 
-func (g *Generic) worker() {
-	for g.processNextWorkItem() {
+// runWorkers runs the controller's pool of workers until ctx is done. Once ctx is done, the controller's
+// work queue is shut down and workers exit after processing objects they are working on.
+func (g *Generic) runWorkers(ctx context.Context, holder Holder) {
+	var wg wait.Group
+	defer wg.Wait()
+	defer holder.queue.shutDown()
+	holder.health.resetWorkers(holder.workers, time.Now())
+	for i := 0; i < int(holder.workers); i++ {
+		id := i
+		wg.Start(func() {
+			defer logz.LogStructuredPanic()
+			g.worker(ctx, holder, id)
+		})
 	}
+	<-ctx.Done()
 }
 
-func (g *Generic) processNextWorkItem() bool {
-	key, quit := g.queue.get()
+func (g *Generic) worker(ctx context.Context, holder Holder, id int) {
+	for g.processNextWorkItem(ctx, holder, id) {
+	}
+}
+
+func (g *Generic) processNextWorkItem(ctx context.Context, holder Holder, id int) bool {
+	key, quit := holder.queue.get()
 	if quit {
 		return false
 	}
-	defer g.queue.done(key)
+	defer holder.queue.done(key)
+	holder.health.startedProcessing(id, key.String(), time.Now())
+	defer func() {
+		holder.health.finishedProcessing(id, time.Now())
+	}()
 
-	holder := g.Controllers[key.gvk]
-	logger := g.logger.With(logz.NamespaceName(key.Namespace),
+	logger := g.logLevels.ObjectLogger(holder.logger, key.gvk, loglevel.ObjectKey{
+		Cluster:   key.Cluster,
+		Namespace: key.Namespace,
+		Name:      key.Name,
+	})
+	logger = logger.With(logz.Cluster(key.Cluster),
+		logz.NamespaceName(key.Namespace),
 		logz.ObjectName(key.Name),
 		logz.ObjectGk(key.gvk.GroupKind()),
 		logz.Iteration(atomic.AddUint32(&g.iter, 1)))
+	cause, hasCause := holder.queue.takeCause(key)
+	if hasCause {
+		logger = logger.With(logz.EnqueueCause(cause))
+	}
 
-	external, retriable, err := g.processKey(logger, holder, key)
-	g.handleErr(logger, holder, external, retriable, err, key)
+	lastKnown := holder.deletedObjects.get(key.QueueKey)
+	if !holder.queue.isOwned(key.QueueKey) {
+		// Key was enqueued before ownership of it has moved to another replica
+		logger.Debug("Skipping object that is not owned by this replica")
+		holder.queue.forget(key)
+		holder.deletedObjects.remove(key.QueueKey, lastKnown)
+		return true
+	}
+	processCtx, span := g.startProcessSpan(ctx, holder, key)
+	result, err := g.processKey(processCtx, logger, holder, key, lastKnown)
+	endProcessSpan(span, result, err)
+	var finished bool
+	if err != nil && ctx.Err() != nil {
+		// Processing was interrupted because the controller is stopping, e.g. on shutdown or loss of leadership.
+		// This is not a failure of the object so the error is not handled. The queue is being shut down, the object
+		// is processed again when workers are restarted because all objects are enqueued then (see enqueueAll).
+		logger.Info("Processing was cancelled", zap.Error(err))
+	} else {
+		finished = g.handleErr(logger, holder, result, err, key)
+	}
+	if finished {
+		holder.deletedObjects.remove(key.QueueKey, lastKnown)
+		// Latency is only observed if the object was processed successfully rather than dropped because of an error
+		if hasCause && err == nil {
+			latency := time.Since(cause.Time)
+			holder.eventProcessLatency.WithLabelValues(holder.AppName, key.gvk.GroupKind().String()).Observe(latency.Seconds())
+			logger.Debug("Processed object after event", zap.Duration("event_latency", latency))
+		}
+	} else if hasCause {
+		// Object will be processed again, latency is measured from the original event
+		holder.queue.setCause(key, cause)
+	}
 
 	return true
 }
 
-func (g *Generic) handleErr(logger *zap.Logger, holder Holder, external bool, retriable bool, err error, key gvkQueueKey) {
+// startProcessSpan starts a span for processing of the key. Requests to the Kubernetes API that are made
+// with the clients of the ProcessContext's cluster are traced as its children (see tracedCluster).
+func (g *Generic) startProcessSpan(ctx context.Context, holder Holder, key gvkQueueKey) (context.Context, trace.Span) {
+	attrs := []attribute.KeyValue{
+		tracing.ControllerKey.String(holder.AppName),
+		tracing.GvkKey.String(key.gvk.String()),
+		tracing.NamespaceKey.String(key.Namespace),
+		tracing.NameKey.String(key.Name),
+	}
+	if key.Cluster != "" {
+		attrs = append(attrs, tracing.ClusterKey.String(key.Cluster))
+	}
+	return g.tracer.Start(ctx, "process "+key.gvk.GroupKind().String(), trace.WithAttributes(attrs...))
+}
+
+// tracedCluster returns a copy of the cluster with clients that trace their requests as children of the span in ctx.
+// Clients of this version of client-go do not accept a context so they have to be constructed for each span.
+// Transports are shared with the cluster's clients and so is the rate limiter if the REST config has one.
+// The cluster is returned as is if the span is not recorded (e.g. if tracing is disabled) or if the cluster
+// has no REST config.
+func tracedCluster(ctx context.Context, cluster ctrl.Cluster) (ctrl.Cluster, error) {
+	if cluster.RestConfig == nil || !trace.SpanFromContext(ctx).IsRecording() {
+		return cluster, nil
+	}
+	config := rest.CopyConfig(cluster.RestConfig)
+	config.WrapTransport = transport.Wrappers(config.WrapTransport, tracing.ContextTransport(ctx))
+	mainClient, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return ctrl.Cluster{}, errors.Wrapf(err, "failed to construct client for cluster %q", cluster.Name)
+	}
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return ctrl.Cluster{}, errors.Wrapf(err, "failed to construct dynamic client for cluster %q", cluster.Name)
+	}
+	return ctrl.Cluster{
+		Name:          cluster.Name,
+		RestConfig:    config,
+		MainClient:    mainClient,
+		DynamicClient: dynamicClient,
+	}, nil
+}
+
+// endProcessSpan records the outcome of processing and ends the span.
+func endProcessSpan(span trace.Span, result ctrl.ProcessResult, err error) {
+	defer span.End()
+	if err != nil {
+		outcome := tracing.OutcomeError
+		if _, retriable := ctrl.ClassifyError(err); retriable {
+			outcome = tracing.OutcomeRetriableError
+		}
+		span.SetAttributes(tracing.OutcomeKey.String(outcome))
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return
+	}
+	if result.Requeue || result.RequeueAfter > 0 {
+		span.SetAttributes(tracing.OutcomeKey.String(tracing.OutcomeRequeue))
+		return
+	}
+	span.SetAttributes(tracing.OutcomeKey.String(tracing.OutcomeSuccess))
+}
+
+// handleErr returns true if processing of the key has finished i.e. it has not been added back to the work queue.
+// Processing has also finished if the object has been dropped because of an error.
+func (g *Generic) handleErr(logger *zap.Logger, holder Holder, result ctrl.ProcessResult, err error, key gvkQueueKey) bool /* finished */ {
 	groupKind := key.gvk.GroupKind()
 
 	if err == nil {
-		g.queue.forget(key)
-		return
+		switch {
+		case result.RequeueAfter > 0:
+			logger.Sugar().Debugf("Requeueing object after %v", result.RequeueAfter)
+			holder.queue.forget(key)
+			holder.queue.addAfter(key, result.RequeueAfter)
+		case result.Requeue:
+			logger.Debug("Requeueing object")
+			holder.queue.addRateLimited(key)
+		default:
+			holder.queue.forget(key)
+			return true
+		}
+		return false
 	}
 
-	if retriable && g.queue.numRequeues(key) < maxRetries {
+	external, retriable := ctrl.ClassifyError(err)
+	namespaceLabel, nameLabel := holder.objectLabels(key.QueueKey)
+	if retriable && (holder.retryPolicy.RetryForever || holder.queue.numRequeues(key) < holder.retryPolicy.MaxRetries) {
 		logger.Info("Error syncing object, will retry", zap.Error(err))
-		g.queue.addRateLimited(key)
+		holder.queue.addRateLimited(key)
 		holder.objectProcessErrors.
-			WithLabelValues(holder.AppName, key.Namespace, key.Name, groupKind.String(), strconv.FormatBool(external), strconv.FormatBool(true)).
+			WithLabelValues(holder.AppName, namespaceLabel, nameLabel, groupKind.String(), strconv.FormatBool(external), strconv.FormatBool(true)).
 			Inc()
-		return
+		return false
 	}
 
 	holder.objectProcessErrors.
-		WithLabelValues(holder.AppName, key.Namespace, key.Name, groupKind.String(), strconv.FormatBool(external), strconv.FormatBool(false)).
+		WithLabelValues(holder.AppName, namespaceLabel, nameLabel, groupKind.String(), strconv.FormatBool(external), strconv.FormatBool(false)).
 		Inc()
 
 	if external {
@@ -75,41 +222,124 @@ func (g *Generic) handleErr(logger *zap.Logger, holder Holder, external bool, re
 	} else {
 		logger.Error("Dropping object out of the queue due to internal error", zap.Error(err))
 	}
-	g.queue.forget(key)
+	if holder.emitDropEvents {
+		g.emitDropEvent(logger, holder, key, err)
+	}
+	holder.queue.forget(key)
+	return true
+}
+
+func (g *Generic) emitDropEvent(logger *zap.Logger, holder Holder, key gvkQueueKey, err error) {
+	if key.Cluster != "" {
+		// Recorder posts events to the main cluster
+		return
+	}
+	obj, exists, getErr := getFromIndexer(g.Informers[key.gvk].GetIndexer(), key.gvk, key.Namespace, key.Name)
+	if getErr != nil {
+		logger.Error("Failed to get object to emit an event", zap.Error(getErr))
+		return
+	}
+	if !exists {
+		return
+	}
+	holder.recorder.Eventf(obj, core_v1.EventTypeWarning, droppedEventReason, "Processing failed: %v", err)
 }
 
-func (g *Generic) processKey(logger *zap.Logger, holder Holder, key gvkQueueKey) (bool /* external */, bool /*retriable*/, error) {
+func (g *Generic) processKey(ctx context.Context, logger *zap.Logger, holder Holder, key gvkQueueKey, lastKnown runtime.Object) (ctrl.ProcessResult, error) {
 	groupKind := key.gvk.GroupKind()
 
 	cntrlr := holder.Cntrlr
-	informer := g.Informers[key.gvk]
+	informer, err := g.informerFor(key)
+	if err != nil {
+		return ctrl.ProcessResult{}, err
+	}
 	obj, exists, err := getFromIndexer(informer.GetIndexer(), key.gvk, key.Namespace, key.Name)
 	if err != nil {
-		return false, false, errors.Wrapf(err, "failed to get object by key %s", key.String())
+		return ctrl.ProcessResult{}, errors.Wrapf(err, "failed to get object by key %s", key.String())
+	}
+	process := cntrlr.Process
+	if exists && holder.finalizer != nil {
+		process = func(pctx *ctrl.ProcessContext) (ctrl.ProcessResult, error) {
+			return holder.finalizer.process(pctx, cntrlr.Process)
+		}
 	}
 	if !exists {
-		logger.Debug("Object not in cache. Was deleted?")
-		return false, false, nil
+		holder.finalizer.forget(key.QueueKey)
+		deletionProcessor, ok := cntrlr.(ctrl.DeletionProcessor)
+		if !ok {
+			logger.Debug("Object not in cache. Was deleted?")
+			return ctrl.ProcessResult{}, nil
+		}
+		logger.Debug("Object not in cache, processing deletion", zap.Bool("last_known_state", lastKnown != nil))
+		if lastKnown != nil {
+			obj = copyObject(lastKnown, key.gvk)
+		}
+		process = func(pctx *ctrl.ProcessContext) (ctrl.ProcessResult, error) {
+			return deletionProcessor.ProcessDeleted(pctx, key.QueueKey)
+		}
 	}
 	startTime := time.Now()
 	logger.Info("Started syncing")
 
 	msg := ""
 	defer func() {
-		totalTime := time.Since(startTime)
-		holder.objectProcessTime.WithLabelValues(holder.AppName, key.Namespace, key.Name, groupKind.String()).Observe(totalTime.Seconds())
+		now := time.Now()
+		totalTime := now.Sub(startTime)
+		namespaceLabel, nameLabel := holder.objectLabels(key.QueueKey)
+		holder.objectProcessTime.WithLabelValues(holder.AppName, namespaceLabel, nameLabel, groupKind.String()).Observe(totalTime.Seconds())
+		g.slowObjects.observe(key, totalTime, now)
 		logger.Sugar().Infof("Synced in %v%s", totalTime, msg)
 	}()
 
-	external, retriable, err := cntrlr.Process(&ctrl.ProcessContext{
-		Logger: logger,
-		Object: obj,
-	})
+	processCtx := ctx
+	if holder.processTimeout > 0 {
+		var cancel context.CancelFunc
+		processCtx, cancel = context.WithTimeout(ctx, holder.processTimeout)
+		defer cancel()
+	}
+	cluster, err := tracedCluster(processCtx, g.clusters[key.Cluster])
+	if err != nil {
+		// Object is processed without tracing of requests rather than not at all
+		logger.Error("Failed to construct clients that trace requests", zap.Error(err))
+		cluster = g.clusters[key.Cluster]
+	}
+	pctx := &ctrl.ProcessContext{
+		Context: processCtx,
+		Logger:  logger,
+		Object:  obj,
+		Cluster: cluster,
+	}
+	var result ctrl.ProcessResult
+	if holder.recoverPanics {
+		result, err = processRecoveringPanic(holder, key, process, pctx)
+	} else {
+		result, err = process(pctx)
+	}
+	if err != nil && ctx.Err() != nil {
+		msg = " (cancelled)"
+	}
+	if err != nil && processCtx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+		msg = " (timed out)"
+		err = ctrl.NewRetriableError(errors.Wrapf(err, "processing timed out after %v", holder.processTimeout))
+	}
 	if err != nil && api_errors.IsConflict(errors.Cause(err)) {
 		msg = " (conflict)"
 		err = nil
 	}
-	return external, retriable, err
+	return result, err
+}
+
+// processRecoveringPanic calls process and turns a panic into a retriable internal error.
+func processRecoveringPanic(holder Holder, key gvkQueueKey, process func(*ctrl.ProcessContext) (ctrl.ProcessResult, error), pctx *ctrl.ProcessContext) (result ctrl.ProcessResult, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			pctx.Logger.Error(fmt.Sprintf("%v", r), logz.Stack(debug.Stack()))
+			holder.objectProcessPanics.WithLabelValues(holder.AppName, key.gvk.GroupKind().String()).Inc()
+			result = ctrl.ProcessResult{}
+			err = ctrl.NewRetriableError(errors.Errorf("recovered panic: %v", r))
+		}
+	}()
+	return process(pctx)
 }
 
 func getFromIndexer(indexer cache.Indexer, gvk schema.GroupVersionKind, namespace, name string) (runtime.Object, bool /*exists */, error) {
@@ -117,9 +347,14 @@ func getFromIndexer(indexer cache.Indexer, gvk schema.GroupVersionKind, namespac
 	if err != nil || !exists {
 		return nil, exists, err
 	}
-	ro := obj.(runtime.Object).DeepCopyObject()
+	return copyObject(obj.(runtime.Object), gvk), true, nil
+}
+
+// copyObject returns a copy of an object from an informer that can be mutated.
+func copyObject(obj runtime.Object, gvk schema.GroupVersionKind) runtime.Object {
+	ro := obj.DeepCopyObject()
 	ro.GetObjectKind().SetGroupVersionKind(gvk) // Objects from type-specific informers don't have GVK set
-	return ro, true, nil
+	return ro
 }
 
 func ByNamespaceAndNameIndexKey(namespace, name string) string {
@@ -128,3 +363,16 @@ func ByNamespaceAndNameIndexKey(namespace, name string) string {
 	}
 	return namespace + "/" + name
 }
+
+// objectLabels returns values of the object namespace and object name labels of per-object metrics according
+// to the cardinality policy. Values are empty if the policy does not allow them.
+func (h *Holder) objectLabels(key ctrl.QueueKey) (string /* namespace */, string /* name */) {
+	switch h.metricsCardinality {
+	case ctrl.MetricsCardinalityObject:
+		return key.Namespace, key.Name
+	case ctrl.MetricsCardinalityNamespace:
+		return key.Namespace, ""
+	default:
+		return "", ""
+	}
+}
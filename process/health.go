@@ -0,0 +1,307 @@
+package process
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	// Names of health checks that are registered by Generic.
+	controllersLiveCheck  = "controllers-live"
+	controllersReadyCheck = "controllers-ready"
+)
+
+// HealthConfig configures when a controller is considered not live.
+type HealthConfig struct {
+	// StuckThreshold is the duration after which a worker that is processing a single object is considered stuck.
+	// Stuck detection is disabled if zero.
+	StuckThreshold time.Duration
+	// InformerEventTimeout is the maximum duration since an informer last observed activity of its watch
+	// after it has synced. Informer event tracking is disabled if zero. Watch activity is an event that changed
+	// an object or a change of the resource version the informer has synced to e.g. because of a watch bookmark
+	// or a relist. Resync events are not counted because they are delivered from the informer's cache even if
+	// its watch is broken. Informers that watch objects that rarely change may be reported as not live, so
+	// the timeout must be longer than the expected interval between changes.
+	InformerEventTimeout time.Duration
+}
+
+// HealthReport is the liveness state of all controllers.
+type HealthReport struct {
+	Live        bool                        `json:"live"`
+	Controllers map[string]ControllerHealth `json:"controllers"`
+}
+
+// ControllerHealth is the liveness state of a controller.
+type ControllerHealth struct {
+	Live bool `json:"live"`
+	// Standby is true if the controller is not run by this replica because it is not leading it.
+	Standby bool `json:"standby,omitempty"`
+	// Problems explain why the controller is not live.
+	Problems  []string         `json:"problems,omitempty"`
+	Informers []InformerHealth `json:"informers"`
+	Workers   []WorkerHealth   `json:"workers"`
+}
+
+// InformerHealth is the state of an informer of a controller.
+type InformerHealth struct {
+	// Cluster is the name of the cluster the informer watches. Empty for the main cluster.
+	Cluster string `json:"cluster,omitempty"`
+	Synced  bool   `json:"synced"`
+	// LastEvent is the last time the informer observed activity of its watch.
+	LastEvent *time.Time `json:"last_event,omitempty"`
+}
+
+// WorkerHealth is the state of a worker of a controller.
+type WorkerHealth struct {
+	ID int `json:"id"`
+	// LastHeartbeat is the last time the worker has started or finished processing an object.
+	LastHeartbeat time.Time `json:"last_heartbeat"`
+	// Processing is the key of the object that is being processed. Empty if the worker is idle.
+	Processing      string     `json:"processing,omitempty"`
+	ProcessingSince *time.Time `json:"processing_since,omitempty"`
+	Stuck           bool       `json:"stuck,omitempty"`
+}
+
+// controllerHealth tracks informers and workers of a controller.
+type controllerHealth struct {
+	informers []*informerHealth
+
+	mu      sync.Mutex
+	workers []workerState
+}
+
+type workerState struct {
+	lastHeartbeat   time.Time
+	processing      string
+	processingSince time.Time
+}
+
+// informerHealth tracks the time an informer last observed activity of its watch.
+type informerHealth struct {
+	cluster  string
+	informer cache.SharedIndexInformer
+	// lastEvent is the last watch activity time in Unix nanoseconds.
+	lastEvent int64
+
+	mu sync.Mutex
+	// resourceVersion is the last observed resource version the informer has synced to.
+	resourceVersion string
+}
+
+func newControllerHealth() *controllerHealth {
+	return &controllerHealth{}
+}
+
+// trackInformer adds an event handler to the informer that records the time of the last event that changed
+// an object. Must be called before informers are started.
+func (h *controllerHealth) trackInformer(cluster string, informer cache.SharedIndexInformer) {
+	inf := &informerHealth{
+		cluster:  cluster,
+		informer: informer,
+	}
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			inf.touch(time.Now())
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			if !isResync(oldObj, newObj) {
+				inf.touch(time.Now())
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			inf.touch(time.Now())
+		},
+	})
+	h.informers = append(h.informers, inf)
+	sort.Slice(h.informers, func(i, j int) bool {
+		return h.informers[i].cluster < h.informers[j].cluster
+	})
+}
+
+// informersSynced records the sync time as the last event time of informers that have not delivered
+// any events so that event timeout is counted from the moment informers synced.
+func (h *controllerHealth) informersSynced(now time.Time) {
+	for _, inf := range h.informers {
+		inf.observeResourceVersion(now)
+		atomic.CompareAndSwapInt64(&inf.lastEvent, 0, now.UnixNano())
+	}
+}
+
+func (i *informerHealth) touch(now time.Time) {
+	atomic.StoreInt64(&i.lastEvent, now.UnixNano())
+}
+
+// observeResourceVersion records watch activity if the resource version the informer has synced to has changed
+// since it was last observed. The resource version changes on watch events, including bookmarks, and on relists.
+func (i *informerHealth) observeResourceVersion(now time.Time) {
+	rv := i.informer.LastSyncResourceVersion()
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if rv != i.resourceVersion {
+		i.resourceVersion = rv
+		i.touch(now)
+	}
+}
+
+// isResync returns true if the update notification was delivered by a resync rather than by a watch event.
+// Resyncs deliver the cached object as both the old and the new object.
+func isResync(oldObj, newObj interface{}) bool {
+	oldMeta, err := meta.Accessor(oldObj)
+	if err != nil {
+		return false
+	}
+	newMeta, err := meta.Accessor(newObj)
+	if err != nil {
+		return false
+	}
+	return oldMeta.GetResourceVersion() == newMeta.GetResourceVersion()
+}
+
+// resetWorkers starts tracking a new pool of workers.
+func (h *controllerHealth) resetWorkers(workers uint, now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.workers = make([]workerState, workers)
+	for i := range h.workers {
+		h.workers[i].lastHeartbeat = now
+	}
+}
+
+// startedProcessing records that the worker started processing the key.
+func (h *controllerHealth) startedProcessing(worker int, key string, now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if worker >= len(h.workers) {
+		return
+	}
+	h.workers[worker] = workerState{
+		lastHeartbeat:   now,
+		processing:      key,
+		processingSince: now,
+	}
+}
+
+// finishedProcessing records that the worker is idle.
+func (h *controllerHealth) finishedProcessing(worker int, now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if worker >= len(h.workers) {
+		return
+	}
+	h.workers[worker] = workerState{
+		lastHeartbeat: now,
+	}
+}
+
+// report returns the liveness state of the controller.
+func (h *controllerHealth) report(config HealthConfig, synced bool, now time.Time) ControllerHealth {
+	result := ControllerHealth{
+		Live:      true,
+		Informers: make([]InformerHealth, 0, len(h.informers)),
+	}
+	for _, inf := range h.informers {
+		infHealth := InformerHealth{
+			Cluster: inf.cluster,
+			Synced:  inf.informer.HasSynced(),
+		}
+		if synced {
+			inf.observeResourceVersion(now)
+		}
+		if lastEvent := atomic.LoadInt64(&inf.lastEvent); lastEvent != 0 {
+			t := time.Unix(0, lastEvent)
+			infHealth.LastEvent = &t
+			if synced && config.InformerEventTimeout > 0 && now.Sub(t) > config.InformerEventTimeout {
+				result.Live = false
+				result.Problems = append(result.Problems, "informer has not observed watch events for "+now.Sub(t).String()+clusterSuffix(inf.cluster))
+			}
+		}
+		result.Informers = append(result.Informers, infHealth)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	result.Workers = make([]WorkerHealth, 0, len(h.workers))
+	for i, w := range h.workers {
+		workerHealth := WorkerHealth{
+			ID:            i,
+			LastHeartbeat: w.lastHeartbeat,
+			Processing:    w.processing,
+		}
+		if w.processing != "" {
+			since := w.processingSince
+			workerHealth.ProcessingSince = &since
+			if config.StuckThreshold > 0 && now.Sub(since) > config.StuckThreshold {
+				workerHealth.Stuck = true
+				result.Live = false
+				result.Problems = append(result.Problems, "worker has been processing "+w.processing+" for "+now.Sub(since).String())
+			}
+		}
+		result.Workers = append(result.Workers, workerHealth)
+	}
+	return result
+}
+
+func clusterSuffix(cluster string) string {
+	if cluster == "" {
+		return ""
+	}
+	return " in cluster " + cluster
+}
+
+// Health returns the liveness state of all controllers. A controller is not live if any of its workers
+// is stuck processing an object or if any of its informers has not observed watch events for too long.
+// Workers of controllers that this replica is not leading are not running so only their informers are checked.
+func (g *Generic) Health() HealthReport {
+	now := time.Now()
+	synced := atomic.LoadInt32(&g.synced) != 0
+	report := HealthReport{
+		Live:        true,
+		Controllers: make(map[string]ControllerHealth, len(g.Controllers)),
+	}
+	for gvk, holder := range g.Controllers {
+		controllerHealth := holder.health.report(g.healthConfig, synced, now)
+		controllerHealth.Standby = atomic.LoadInt32(&g.electing) != 0 && !holder.state.isLeading()
+		if !controllerHealth.Live {
+			report.Live = false
+		}
+		report.Controllers[gvk.String()] = controllerHealth
+	}
+	return report
+}
+
+// IsLive returns true if all controllers are live. See Health.
+func (g *Generic) IsLive() bool {
+	return g.Health().Live
+}
+
+// checkLive is a liveness check that fails if any controller is not live.
+func (g *Generic) checkLive(_ context.Context) error {
+	report := g.Health()
+	if report.Live {
+		return nil
+	}
+	var problems []string
+	for name, controller := range report.Controllers {
+		for _, problem := range controller.Problems {
+			problems = append(problems, name+": "+problem)
+		}
+	}
+	sort.Strings(problems)
+	return errors.Errorf("controllers are not live: %s", strings.Join(problems, "; "))
+}
+
+// checkReady is a readiness check that fails if Generic is not ready. See IsReady.
+func (g *Generic) checkReady(_ context.Context) error {
+	if !g.IsReady() {
+		return errors.New("controllers are not ready")
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package process
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/atlassian/ctrl"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	core_v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	core_v1inf "k8s.io/client-go/informers/core/v1"
+)
+
+func TestGenericStuckWorkerIsNotLive(t *testing.T) {
+	t.Parallel()
+
+	config := testConfig(t,
+		&core_v1.ConfigMap{ObjectMeta: meta_v1.ObjectMeta{Namespace: "ns", Name: "cm"}},
+	)
+	config.LivenessStuckThreshold = 50 * time.Millisecond
+
+	unblock := make(chan struct{})
+	generic, err := NewGeneric(config, 1, &fakeConstructor{
+		descr:       ctrl.Descriptor{Gvk: configMapGvk},
+		newInformer: core_v1inf.NewConfigMapInformer,
+		process: func(pctx *ctrl.ProcessContext) (ctrl.ProcessResult, error) {
+			<-unblock
+			return ctrl.ProcessResult{}, nil
+		},
+	})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- generic.Run(ctx)
+	}()
+
+	err = wait.PollImmediate(10*time.Millisecond, 5*time.Second, func() (bool, error) {
+		return !generic.IsLive(), nil
+	})
+	require.NoError(t, err)
+	// Readiness does not depend on liveness
+	assert.True(t, generic.IsReady())
+	health := generic.Health().Controllers[configMapGvk.String()]
+	require.Len(t, health.Informers, 1)
+	assert.True(t, health.Informers[0].Synced)
+	require.Len(t, health.Workers, 1)
+	assert.True(t, health.Workers[0].Stuck)
+	assert.Contains(t, health.Workers[0].Processing, "N=cm")
+	assert.NotEmpty(t, health.Problems)
+
+	close(unblock)
+	err = wait.PollImmediate(10*time.Millisecond, 5*time.Second, func() (bool, error) {
+		return generic.IsLive(), nil
+	})
+	require.NoError(t, err)
+	health = generic.Health().Controllers[configMapGvk.String()]
+	assert.True(t, health.Live)
+	assert.Empty(t, health.Workers[0].Processing)
+
+	cancel()
+	assert.Equal(t, context.Canceled, <-runErr)
+}
+
+func TestGenericInformerWithoutWatchEventsIsNotLive(t *testing.T) {
+	t.Parallel()
+
+	config := testConfig(t,
+		&core_v1.ConfigMap{ObjectMeta: meta_v1.ObjectMeta{Namespace: "ns", Name: "cm"}},
+	)
+	// Resync events are delivered more often than the timeout but are not watch events.
+	// Resync period is the minimum allowed by informers.
+	config.ResyncPeriod = time.Second
+	config.LivenessInformerEventTimeout = 1500 * time.Millisecond
+
+	generic, err := NewGeneric(config, 1, &fakeConstructor{
+		descr:       ctrl.Descriptor{Gvk: configMapGvk},
+		newInformer: core_v1inf.NewConfigMapInformer,
+		process: func(pctx *ctrl.ProcessContext) (ctrl.ProcessResult, error) {
+			return ctrl.ProcessResult{}, nil
+		},
+	})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- generic.Run(ctx)
+	}()
+
+	err = wait.PollImmediate(10*time.Millisecond, 5*time.Second, func() (bool, error) {
+		return !generic.IsLive(), nil
+	})
+	require.NoError(t, err)
+	health := generic.Health().Controllers[configMapGvk.String()]
+	require.Len(t, health.Problems, 1)
+	assert.Contains(t, health.Problems[0], "informer has not observed watch events")
+
+	// A watch event makes the controller live again
+	_, err = config.MainClient.CoreV1().ConfigMaps("ns").Create(&core_v1.ConfigMap{ObjectMeta: meta_v1.ObjectMeta{Namespace: "ns", Name: "new"}})
+	require.NoError(t, err)
+	err = wait.PollImmediate(10*time.Millisecond, 5*time.Second, func() (bool, error) {
+		return generic.IsLive(), nil
+	})
+	require.NoError(t, err)
+
+	cancel()
+	assert.Equal(t, context.Canceled, <-runErr)
+}
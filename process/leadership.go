@@ -0,0 +1,205 @@
+package process
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/ash2k/stager"
+	"github.com/atlassian/ctrl/logz"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/sync/errgroup"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// Elector blocks until leadership is acquired or ctx is done. Returned context is done once leadership is lost.
+// Returned release function must be called once all work that requires leadership has stopped.
+type Elector func(ctx context.Context) (context.Context, func(), error)
+
+// controllerState tracks whether a controller has been started and whether this replica is leading it.
+type controllerState struct {
+	started int32
+	leading int32
+}
+
+func (s *controllerState) isStarted() bool {
+	return atomic.LoadInt32(&s.started) != 0
+}
+
+func (s *controllerState) isLeading() bool {
+	return atomic.LoadInt32(&s.leading) != 0
+}
+
+func (s *controllerState) setLeading(leading bool) {
+	var val int32
+	if leading {
+		val = 1
+	}
+	atomic.StoreInt32(&s.leading, val)
+}
+
+// leaderElectionGroup is a set of controllers that share a leader election lock.
+type leaderElectionGroup struct {
+	elect       Elector
+	controllers map[schema.GroupVersionKind]Holder
+}
+
+// RunWithLeaderElection is like Run but it does not require leadership to start and sync informers and to run
+// servers. Servers run on all replicas. Controllers are run before leadership is acquired if runControllers is true. Workers are started once
+// leadership is acquired. If leadership is lost, workers are stopped and leadership is contended for again,
+// while informers, controllers and servers keep running.
+// Controllers that have a separate leader election lock contend for leadership using the Elector returned by
+// electController, all other controllers share elect. This allows different replicas to lead different controllers.
+func (g *Generic) RunWithLeaderElection(ctx context.Context, elect Elector, electController func(schema.GroupVersionKind) Elector, runControllers bool) error {
+	groups, err := g.leaderElectionGroups(elect, electController)
+	if err != nil {
+		return err
+	}
+	atomic.StoreInt32(&g.electing, 1)
+
+	// Stager will perform ordered, graceful shutdown
+	stgr := stager.New()
+	defer stgr.Shutdown()
+
+	if err = g.startInformers(ctx, stgr.NextStage()); err != nil {
+		return err
+	}
+
+	controllersStage := stgr.NextStage()
+	if runControllers {
+		if err = g.startControllers(ctx, controllersStage, g.Controllers); err != nil {
+			return err
+		}
+	}
+	group, groupCtx := errgroup.WithContext(ctx)
+	group.Go(func() error {
+		return g.runServers(groupCtx)
+	})
+	for _, leGroup := range groups {
+		leGroup := leGroup // capture field into a scoped variable to avoid data race
+		group.Go(func() error {
+			return g.runWorkersWhileLeading(groupCtx, leGroup, func() error {
+				// Controllers that have already been started are skipped
+				return g.startControllers(groupCtx, controllersStage, leGroup.controllers)
+			})
+		})
+	}
+	return group.Wait()
+}
+
+// HasSeparateLeaderElection returns true if at least one controller contends for leadership using
+// its own leader election lock.
+func (g *Generic) HasSeparateLeaderElection() bool {
+	for _, holder := range g.Controllers {
+		if holder.separateLeaderElection {
+			return true
+		}
+	}
+	return false
+}
+
+// Leadership returns whether this replica is leading each of the controllers i.e. whether it is running their workers.
+func (g *Generic) Leadership() map[schema.GroupVersionKind]bool {
+	leadership := make(map[schema.GroupVersionKind]bool, len(g.Controllers))
+	for gvk, holder := range g.Controllers {
+		leadership[gvk] = holder.state.isLeading()
+	}
+	return leadership
+}
+
+func (g *Generic) leaderElectionGroups(elect Elector, electController func(schema.GroupVersionKind) Elector) ([]leaderElectionGroup, error) {
+	shared := leaderElectionGroup{
+		elect:       elect,
+		controllers: make(map[schema.GroupVersionKind]Holder),
+	}
+	var groups []leaderElectionGroup
+	for gvk, holder := range g.Controllers {
+		if !holder.separateLeaderElection {
+			shared.controllers[gvk] = holder
+			continue
+		}
+		if electController == nil {
+			return nil, errors.Errorf("controller for GVK %s requires a separate leader election lock", gvk)
+		}
+		groups = append(groups, leaderElectionGroup{
+			elect: electController(gvk),
+			controllers: map[schema.GroupVersionKind]Holder{
+				gvk: holder,
+			},
+		})
+	}
+	if len(shared.controllers) > 0 {
+		groups = append(groups, shared)
+	}
+	return groups, nil
+}
+
+// runWorkersWhileLeading contends for leadership and runs workers of the group's controllers while leading.
+// onLeading is called each time leadership is acquired, before workers are started.
+func (g *Generic) runWorkersWhileLeading(ctx context.Context, group leaderElectionGroup, onLeading func() error) error {
+	for {
+		leaderCtx, release, err := group.elect(ctx)
+		if err != nil {
+			return err
+		}
+		if err = onLeading(); err != nil {
+			release()
+			return err
+		}
+		var wg wait.Group
+		for gvk, c := range group.controllers {
+			c := c // capture field into a scoped variable to avoid data race
+			// Objects may have been processed by another leader or not processed at all since this replica
+			// has been leading the last time. Enqueue all of them to make sure nothing is missed.
+			g.enqueueAll(gvk, c)
+			c.state.setLeading(true)
+			wg.StartWithContext(leaderCtx, func(ctx context.Context) {
+				defer logz.LogStructuredPanic()
+				g.runWorkers(ctx, c)
+			})
+		}
+		wg.Wait()
+		for _, c := range group.controllers {
+			c.state.setLeading(false)
+		}
+		release()
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		for gvk, c := range group.controllers {
+			g.logger.Info("Leadership lost, stopped workers", logz.ObjectGk(gvk.GroupKind()))
+			c.queue.restart()
+		}
+	}
+}
+
+type leadershipCollector struct {
+	holders map[schema.GroupVersionKind]Holder
+	leader  *prometheus.Desc
+}
+
+func newLeadershipCollector(holders map[schema.GroupVersionKind]Holder) *leadershipCollector {
+	return &leadershipCollector{
+		holders: holders,
+		leader: prometheus.NewDesc(
+			prometheus.BuildFQName(metricsNamespace, "", "controller_leader"),
+			"Whether this replica is leading the controller (1) or not (0)",
+			[]string{"controller", "groupkind"}, nil,
+		),
+	}
+}
+
+func (c *leadershipCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.leader
+}
+
+func (c *leadershipCollector) Collect(ch chan<- prometheus.Metric) {
+	for gvk, holder := range c.holders {
+		var leading float64
+		if holder.state.isLeading() {
+			leading = 1
+		}
+		ch <- prometheus.MustNewConstMetric(c.leader, prometheus.GaugeValue, leading, holder.AppName, gvk.GroupKind().String())
+	}
+}
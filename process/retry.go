@@ -0,0 +1,119 @@
+package process
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/atlassian/ctrl"
+	"golang.org/x/time/rate"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/workqueue"
+)
+
+const (
+	// defaultMaxRetries is the number of times an object will be retried before it is dropped out of the queue.
+	// With the default rate-limiter (5ms*2^(maxRetries-1)) the following numbers represent the times
+	// an object is going to be requeued:
+	//
+	// 5ms, 10ms, 20ms, 40ms, 80ms, 160ms, 320ms, 640ms, 1.3s, 2.6s, 5.1s, 10.2s, 20.4s, 41s, 82s
+	defaultMaxRetries     = 15
+	defaultRetryBaseDelay = 5 * time.Millisecond
+	defaultRetryMaxDelay  = 1000 * time.Second
+
+	// Overall rate limit for retries, same as in workqueue.DefaultControllerRateLimiter().
+	retryQPS   = 10
+	retryBurst = 100
+)
+
+// retryPolicy returns the effective retry policy for a controller.
+// Non-nil fields of override take precedence over the controller's policy, zero fields are set to defaults.
+// MaxRetries of the effective policy is zero if retries are disabled.
+func retryPolicy(policy ctrl.RetryPolicy, override ctrl.RetryPolicyOverride) ctrl.RetryPolicy {
+	if override.MaxRetries != nil {
+		policy.MaxRetries = *override.MaxRetries
+	}
+	if override.RetryForever != nil {
+		policy.RetryForever = *override.RetryForever
+	}
+	if override.BaseDelay != nil {
+		policy.BaseDelay = *override.BaseDelay
+	}
+	if override.MaxDelay != nil {
+		policy.MaxDelay = *override.MaxDelay
+	}
+	if override.Jitter != nil {
+		policy.Jitter = *override.Jitter
+	}
+	switch {
+	case policy.MaxRetries == 0:
+		policy.MaxRetries = defaultMaxRetries
+	case policy.MaxRetries < 0:
+		policy.MaxRetries = 0
+	}
+	if policy.BaseDelay == 0 {
+		policy.BaseDelay = defaultRetryBaseDelay
+	}
+	if policy.MaxDelay == 0 {
+		policy.MaxDelay = defaultRetryMaxDelay
+	}
+	return policy
+}
+
+// newRetryRateLimiter constructs a rate limiter that behaves like workqueue.DefaultControllerRateLimiter()
+// but uses delays from the retry policy.
+func newRetryRateLimiter(policy ctrl.RetryPolicy) workqueue.RateLimiter {
+	return workqueue.NewMaxOfRateLimiter(
+		&backoffRateLimiter{
+			failures:  make(map[interface{}]int),
+			baseDelay: policy.BaseDelay,
+			maxDelay:  policy.MaxDelay,
+			jitter:    policy.Jitter,
+		},
+		&workqueue.BucketRateLimiter{Limiter: rate.NewLimiter(rate.Limit(retryQPS), retryBurst)},
+	)
+}
+
+// backoffRateLimiter is like workqueue.ItemExponentialFailureRateLimiter but adds a random jitter
+// to the delays.
+type backoffRateLimiter struct {
+	mu       sync.Mutex
+	failures map[interface{}]int
+
+	baseDelay time.Duration
+	maxDelay  time.Duration
+	jitter    float64
+}
+
+func (r *backoffRateLimiter) When(item interface{}) time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	exp := r.failures[item]
+	r.failures[item] = exp + 1
+
+	// The backoff is capped such that 'calculated' value never overflows.
+	backoff := float64(r.baseDelay.Nanoseconds()) * math.Pow(2, float64(exp))
+	delay := r.maxDelay
+	if backoff < float64(r.maxDelay.Nanoseconds()) {
+		delay = time.Duration(backoff)
+	}
+	if r.jitter > 0 {
+		delay = wait.Jitter(delay, r.jitter)
+	}
+	return delay
+}
+
+func (r *backoffRateLimiter) NumRequeues(item interface{}) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.failures[item]
+}
+
+func (r *backoffRateLimiter) Forget(item interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.failures, item)
+}
@@ -0,0 +1,149 @@
+package process
+
+import (
+	"testing"
+	"time"
+
+	"github.com/atlassian/ctrl"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryPolicyDefaultsAndOverrides(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name     string
+		policy   ctrl.RetryPolicy
+		override ctrl.RetryPolicyOverride
+		expected ctrl.RetryPolicy
+	}{
+		{
+			name: "defaults",
+			expected: ctrl.RetryPolicy{
+				MaxRetries: defaultMaxRetries,
+				BaseDelay:  defaultRetryBaseDelay,
+				MaxDelay:   defaultRetryMaxDelay,
+			},
+		},
+		{
+			name: "controller policy",
+			policy: ctrl.RetryPolicy{
+				MaxRetries: 3,
+				BaseDelay:  time.Second,
+				Jitter:     0.5,
+			},
+			expected: ctrl.RetryPolicy{
+				MaxRetries: 3,
+				BaseDelay:  time.Second,
+				MaxDelay:   defaultRetryMaxDelay,
+				Jitter:     0.5,
+			},
+		},
+		{
+			name: "override",
+			policy: ctrl.RetryPolicy{
+				MaxRetries: 3,
+				BaseDelay:  time.Second,
+				MaxDelay:   time.Minute,
+			},
+			override: ctrl.RetryPolicyOverride{
+				RetryForever: boolPtr(true),
+				MaxDelay:     durationPtr(time.Hour),
+			},
+			expected: ctrl.RetryPolicy{
+				MaxRetries:   3,
+				RetryForever: true,
+				BaseDelay:    time.Second,
+				MaxDelay:     time.Hour,
+			},
+		},
+		{
+			name: "override disables retries",
+			policy: ctrl.RetryPolicy{
+				MaxRetries:   3,
+				RetryForever: true,
+				Jitter:       0.5,
+			},
+			override: ctrl.RetryPolicyOverride{
+				MaxRetries:   intPtr(ctrl.NoRetries),
+				RetryForever: boolPtr(false),
+				Jitter:       float64Ptr(0),
+			},
+			expected: ctrl.RetryPolicy{
+				BaseDelay: defaultRetryBaseDelay,
+				MaxDelay:  defaultRetryMaxDelay,
+			},
+		},
+		{
+			name: "controller policy disables retries",
+			policy: ctrl.RetryPolicy{
+				MaxRetries: ctrl.NoRetries,
+			},
+			expected: ctrl.RetryPolicy{
+				BaseDelay: defaultRetryBaseDelay,
+				MaxDelay:  defaultRetryMaxDelay,
+			},
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, c.expected, retryPolicy(c.policy, c.override))
+		})
+	}
+}
+
+func TestBackoffRateLimiter(t *testing.T) {
+	t.Parallel()
+
+	limiter := &backoffRateLimiter{
+		failures:  make(map[interface{}]int),
+		baseDelay: time.Second,
+		maxDelay:  5 * time.Second,
+	}
+	assert.Equal(t, time.Second, limiter.When("a"))
+	assert.Equal(t, 2*time.Second, limiter.When("a"))
+	assert.Equal(t, 4*time.Second, limiter.When("a"))
+	assert.Equal(t, 5*time.Second, limiter.When("a"))
+	assert.Equal(t, time.Second, limiter.When("b"))
+	assert.Equal(t, 4, limiter.NumRequeues("a"))
+
+	limiter.Forget("a")
+	assert.Zero(t, limiter.NumRequeues("a"))
+	assert.Equal(t, time.Second, limiter.When("a"))
+}
+
+func TestBackoffRateLimiterJitter(t *testing.T) {
+	t.Parallel()
+
+	limiter := &backoffRateLimiter{
+		failures:  make(map[interface{}]int),
+		baseDelay: time.Second,
+		maxDelay:  time.Minute,
+		jitter:    0.5,
+	}
+	for i := 0; i < 100; i++ {
+		limiter.Forget("a")
+		delay := limiter.When("a")
+		assert.True(t, delay >= time.Second && delay <= 1500*time.Millisecond, "unexpected delay %s", delay)
+	}
+}
+
+func intPtr(i int) *int {
+	return &i
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
+
+func durationPtr(d time.Duration) *time.Duration {
+	return &d
+}
+
+func float64Ptr(f float64) *float64 {
+	return &f
+}
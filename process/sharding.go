@@ -0,0 +1,340 @@
+package process
+
+import (
+	"context"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/atlassian/ctrl"
+	"github.com/atlassian/ctrl/logz"
+	"go.uber.org/zap"
+	coordination_v1 "k8s.io/api/coordination/v1"
+	api_errors "k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+	coordination_v1client "k8s.io/client-go/kubernetes/typed/coordination/v1"
+)
+
+const (
+	// ShardGroupLabel is the label that is set on Leases of the members of a shard group.
+	ShardGroupLabel = "ctrl.atlassian.com/shard-group"
+
+	// Number of points each member has on the hash ring. More points give more even distribution of keys.
+	ringPointsPerMember = 100
+
+	renewJitterFactor = 0.1
+)
+
+// ShardingConfig configures key-based sharding of work across replicas.
+type ShardingConfig struct {
+	// Namespace where Leases of the members are stored.
+	Namespace string
+	// Group is the name of the shard group. Replicas with the same group share work.
+	Group string
+	// Identity of this replica. Must be unique within the group.
+	Identity string
+	// LeaseDuration is the duration after which a member that has not renewed its Lease is considered gone.
+	LeaseDuration time.Duration
+	// RenewPeriod is the interval between renewals of the member's Lease and checks for membership changes.
+	RenewPeriod time.Duration
+	// ByNamespace makes all objects in a namespace owned by the same replica.
+	ByNamespace bool
+}
+
+// Sharder maintains membership of this replica in a shard group and decides which keys this replica owns.
+// Members discover each other via Leases and keys are distributed between members using a consistent hash ring
+// so that membership changes only move a small portion of keys between members.
+//
+// Members observe membership changes at different times. To avoid two members processing the same key at once,
+// a key that moves to this replica is only owned once a grace period of one lease duration has passed since
+// the change was observed. By then the previous owner has observed the change and stopped taking the key,
+// or its own Lease has expired. Keys that move away from this replica stop being owned immediately.
+type Sharder struct {
+	logger *zap.Logger
+	client coordination_v1client.LeasesGetter
+	config ShardingConfig
+
+	mu   sync.RWMutex
+	ring *hashRing
+	// stableRing is the ring that was in effect before the last membership change. Keys that are owned
+	// according to both rings are owned during the grace period.
+	stableRing *hashRing
+	// graceEnd is the time the grace period after the last membership change ends. Zero if it has ended.
+	graceEnd time.Time
+	// lastRenew is the time of the last successful renewal of this replica's Lease.
+	lastRenew time.Time
+}
+
+func NewSharder(logger *zap.Logger, client coordination_v1client.LeasesGetter, config ShardingConfig) *Sharder {
+	return &Sharder{
+		logger:     logger,
+		client:     client,
+		config:     config,
+		ring:       newHashRing(nil),
+		stableRing: newHashRing(nil),
+	}
+}
+
+// Owns returns true if this replica owns the key. No keys are owned until membership is established and
+// the grace period after it has passed, or if this replica failed to renew its Lease in time.
+func (s *Sharder) Owns(key ctrl.QueueKey) bool {
+	shardKey := key.Namespace
+	if key.Cluster != "" {
+		shardKey = key.Cluster + "/" + shardKey
+	}
+	if !s.config.ByNamespace {
+		shardKey += "/" + key.Name
+	}
+	now := time.Now()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if now.Sub(s.lastRenew) >= s.config.LeaseDuration {
+		// Other members consider this replica gone and may have taken over its keys
+		return false
+	}
+	if s.ring.owner(shardKey) != s.config.Identity {
+		return false
+	}
+	return s.stableRing.owner(shardKey) == s.config.Identity || !now.Before(s.graceEnd)
+}
+
+// Members returns identities of current members of the shard group.
+func (s *Sharder) Members() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.ring.members
+}
+
+// Run maintains membership until ctx is done. onChange is called each time the set of members changes and
+// each time the grace period after a change ends i.e. each time the set of owned keys may have changed.
+// This replica's Lease is deleted once ctx is done so that its keys are taken over by other members
+// without waiting for the Lease to expire.
+func (s *Sharder) Run(ctx context.Context, onChange func()) {
+	defer s.leave()
+	wait.JitterUntil(func() {
+		s.renew()
+		changed := s.updateMembers()
+		if s.endGracePeriod() || changed {
+			onChange()
+		}
+	}, s.config.RenewPeriod, renewJitterFactor, true, ctx.Done())
+}
+
+func (s *Sharder) leaseName() string {
+	return s.config.Group + "-" + s.config.Identity
+}
+
+// renew creates or renews this replica's Lease.
+func (s *Sharder) renew() {
+	now := meta_v1.NewMicroTime(time.Now())
+	leaseDurationSeconds := int32(s.config.LeaseDuration / time.Second)
+	leases := s.client.Leases(s.config.Namespace)
+	lease, err := leases.Get(s.leaseName(), meta_v1.GetOptions{})
+	switch {
+	case api_errors.IsNotFound(err):
+		_, err = leases.Create(&coordination_v1.Lease{
+			ObjectMeta: meta_v1.ObjectMeta{
+				Namespace: s.config.Namespace,
+				Name:      s.leaseName(),
+				Labels: map[string]string{
+					ShardGroupLabel: s.config.Group,
+				},
+			},
+			Spec: coordination_v1.LeaseSpec{
+				HolderIdentity:       &s.config.Identity,
+				LeaseDurationSeconds: &leaseDurationSeconds,
+				AcquireTime:          &now,
+				RenewTime:            &now,
+			},
+		})
+	case err == nil:
+		lease.Spec.HolderIdentity = &s.config.Identity
+		lease.Spec.LeaseDurationSeconds = &leaseDurationSeconds
+		lease.Spec.RenewTime = &now
+		_, err = leases.Update(lease)
+	}
+	if err != nil {
+		s.logger.Error("Failed to renew shard membership lease", logz.NamespaceName(s.config.Namespace), zap.Error(err))
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastRenew = now.Time
+}
+
+// updateMembers rebuilds the hash ring from the live Leases of the group. Returns true if members have changed.
+func (s *Sharder) updateMembers() bool {
+	now := time.Now()
+	var members []string
+	s.mu.RLock()
+	isMember := now.Sub(s.lastRenew) < s.config.LeaseDuration
+	s.mu.RUnlock()
+	if isMember {
+		// This replica must not process any keys if it failed to renew its Lease because other members
+		// consider it gone and have taken over its keys.
+		list, err := s.client.Leases(s.config.Namespace).List(meta_v1.ListOptions{
+			LabelSelector: labels.SelectorFromSet(labels.Set{ShardGroupLabel: s.config.Group}).String(),
+		})
+		if err != nil {
+			s.logger.Error("Failed to list shard membership leases", logz.NamespaceName(s.config.Namespace), zap.Error(err))
+			return false
+		}
+		for _, lease := range list.Items {
+			if isLive(lease.Spec, now) {
+				members = append(members, *lease.Spec.HolderIdentity)
+			}
+		}
+	}
+	ring := newHashRing(members)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if equalMembers(s.ring.members, ring.members) {
+		return false
+	}
+	s.logger.Info("Shard group membership changed", zap.Strings("members", ring.members))
+	// The stable ring is kept if the grace period after the previous change has not ended because keys that
+	// moved to this replica then may still be processed by their previous owner
+	if s.graceEnd.IsZero() {
+		s.stableRing = s.ring
+	}
+	s.ring = ring
+	s.graceEnd = now.Add(s.config.LeaseDuration)
+	return true
+}
+
+// endGracePeriod ends the grace period after a membership change if it has passed.
+// Returns true if the grace period has ended.
+func (s *Sharder) endGracePeriod() bool {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.graceEnd.IsZero() || now.Before(s.graceEnd) {
+		return false
+	}
+	s.stableRing = s.ring
+	s.graceEnd = time.Time{}
+	return true
+}
+
+func (s *Sharder) leave() {
+	err := s.client.Leases(s.config.Namespace).Delete(s.leaseName(), &meta_v1.DeleteOptions{})
+	if err != nil && !api_errors.IsNotFound(err) {
+		s.logger.Error("Failed to delete shard membership lease", logz.NamespaceName(s.config.Namespace), zap.Error(err))
+		return
+	}
+	s.logger.Info("Left shard group")
+}
+
+func isLive(spec coordination_v1.LeaseSpec, now time.Time) bool {
+	if spec.HolderIdentity == nil || spec.RenewTime == nil || spec.LeaseDurationSeconds == nil {
+		return false
+	}
+	return spec.RenewTime.Add(time.Duration(*spec.LeaseDurationSeconds) * time.Second).After(now)
+}
+
+func equalMembers(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// hashRing is an immutable consistent hash ring.
+type hashRing struct {
+	// members are sorted identities of members.
+	members []string
+	// points are sorted hashes of members' points on the ring.
+	points []uint64
+	owners map[uint64]string
+}
+
+func newHashRing(members []string) *hashRing {
+	sorted := append([]string(nil), members...)
+	sort.Strings(sorted)
+	ring := &hashRing{
+		members: sorted,
+		owners:  make(map[uint64]string, len(sorted)*ringPointsPerMember),
+	}
+	for _, member := range sorted {
+		for i := 0; i < ringPointsPerMember; i++ {
+			point := hash(member + "#" + strconv.Itoa(i))
+			if _, ok := ring.owners[point]; ok {
+				// Collision, first member in sorted order wins
+				continue
+			}
+			ring.owners[point] = member
+			ring.points = append(ring.points, point)
+		}
+	}
+	sort.Slice(ring.points, func(i, j int) bool {
+		return ring.points[i] < ring.points[j]
+	})
+	return ring
+}
+
+// owner returns the member that owns the key or an empty string if the ring has no members.
+func (r *hashRing) owner(key string) string {
+	if len(r.points) == 0 {
+		return ""
+	}
+	h := hash(key)
+	i := sort.Search(len(r.points), func(i int) bool {
+		return r.points[i] >= h
+	})
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.owners[r.points[i]]
+}
+
+// hash returns a well distributed hash of s. FNV alone distributes similar strings poorly, so its result
+// is mixed using the MurmurHash3 finalizer.
+func hash(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s)) // nolint: errcheck, gosec
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+// RunWithSharding is like Run but this replica only processes keys it owns according to sharder.
+// Keys that are not owned are not added to work queues and are skipped by workers. When ownership changes, all
+// objects are enqueued again so that this replica picks up keys it has become the owner of.
+// Keys are taken over by a new owner one lease duration after it observes a membership change (see Sharder).
+// A key that was being processed by its previous owner when the change happened is not interrupted, so two replicas
+// may process the same object at once if processing takes longer than that. Set a process timeout shorter than
+// the lease duration to prevent it.
+func (g *Generic) RunWithSharding(ctx context.Context, sharder *Sharder) error {
+	for _, holder := range g.Controllers {
+		holder.queue.setOwns(sharder.Owns)
+		holder.deletedObjects.setOwns(sharder.Owns)
+	}
+	// Membership is maintained until all workers have stopped
+	sharderCtx, cancel := context.WithCancel(context.Background())
+	var wg wait.Group
+	defer wg.Wait()
+	defer cancel()
+	wg.Start(func() {
+		defer logz.LogStructuredPanic()
+		sharder.Run(sharderCtx, func() {
+			for gvk, holder := range g.Controllers {
+				g.enqueueAll(gvk, holder)
+			}
+		})
+	})
+	return g.Run(ctx)
+}
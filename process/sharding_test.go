@@ -0,0 +1,114 @@
+package process
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/atlassian/ctrl"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestHashRingMovesFewKeysOnMembershipChange(t *testing.T) {
+	t.Parallel()
+
+	before := newHashRing([]string{"a", "b", "c"})
+	after := newHashRing([]string{"a", "b", "c", "d"})
+	const keys = 10000
+	owned := make(map[string]int)
+	moved := 0
+	for i := 0; i < keys; i++ {
+		key := "ns/" + strconv.Itoa(i)
+		ownerBefore := before.owner(key)
+		ownerAfter := after.owner(key)
+		owned[ownerBefore]++
+		if ownerBefore != ownerAfter {
+			// Keys only move to the new member
+			assert.Equal(t, "d", ownerAfter)
+			moved++
+		}
+	}
+	for _, member := range before.members {
+		assert.InDelta(t, keys/3, owned[member], keys/10, member)
+	}
+	assert.InDelta(t, keys/4, moved, keys/10)
+	assert.Empty(t, newHashRing(nil).owner("ns/name"))
+}
+
+// passGracePeriod makes the grace period after the last membership change pass.
+func passGracePeriod(t *testing.T, s *Sharder) {
+	s.mu.Lock()
+	s.graceEnd = time.Now()
+	s.mu.Unlock()
+	require.True(t, s.endGracePeriod())
+}
+
+func TestShardersSplitKeys(t *testing.T) {
+	t.Parallel()
+
+	client := fake.NewSimpleClientset()
+	newSharder := func(identity string) *Sharder {
+		return NewSharder(zaptest.NewLogger(t), client.CoordinationV1(), ShardingConfig{
+			Namespace:     "ns",
+			Group:         "group",
+			Identity:      identity,
+			LeaseDuration: time.Minute,
+			RenewPeriod:   time.Second,
+		})
+	}
+	a := newSharder("a")
+	b := newSharder("b")
+
+	// No keys are owned until membership is established and the grace period has passed
+	key := ctrl.QueueKey{Namespace: "ns", Name: "name"}
+	assert.False(t, a.Owns(key))
+	a.renew()
+	assert.True(t, a.updateMembers())
+	assert.False(t, a.Owns(key))
+	assert.False(t, a.endGracePeriod())
+	passGracePeriod(t, a)
+	assert.True(t, a.Owns(key))
+
+	b.renew()
+	assert.True(t, a.updateMembers())
+	assert.True(t, b.updateMembers())
+	assert.False(t, b.updateMembers())
+	assert.Equal(t, []string{"a", "b"}, a.Members())
+
+	// Keys that moved to b are not owned by anyone until b's grace period has passed
+	ownedByA := 0
+	for i := 0; i < 1000; i++ {
+		key := ctrl.QueueKey{Namespace: "ns", Name: strconv.Itoa(i)}
+		assert.False(t, b.Owns(key))
+		if a.Owns(key) {
+			ownedByA++
+		}
+	}
+	assert.InDelta(t, 500, ownedByA, 150)
+
+	passGracePeriod(t, a)
+	passGracePeriod(t, b)
+	for i := 0; i < 1000; i++ {
+		key := ctrl.QueueKey{Namespace: "ns", Name: strconv.Itoa(i)}
+		// Each key is owned by exactly one member
+		assert.NotEqual(t, a.Owns(key), b.Owns(key))
+	}
+
+	// Keys of a member that has left are taken over once the grace period has passed
+	b.leave()
+	assert.True(t, a.updateMembers())
+	assert.Equal(t, []string{"a"}, a.Members())
+	passGracePeriod(t, a)
+	for i := 0; i < 1000; i++ {
+		assert.True(t, a.Owns(ctrl.QueueKey{Namespace: "ns", Name: strconv.Itoa(i)}))
+	}
+
+	// No keys are owned once this replica's Lease has expired
+	a.mu.Lock()
+	a.lastRenew = time.Now().Add(-time.Minute)
+	a.mu.Unlock()
+	assert.False(t, a.Owns(key))
+}
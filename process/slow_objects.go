@@ -0,0 +1,105 @@
+package process
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/atlassian/ctrl"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// SlowObject is an object that took long to process.
+type SlowObject struct {
+	GroupKind string `json:"groupkind"`
+	// Cluster is the name of the cluster the object is in. Empty for the main cluster.
+	Cluster   string `json:"cluster,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+	// DurationSeconds is how long the last processing of the object took.
+	DurationSeconds float64 `json:"duration_seconds"`
+	// ProcessedAt is the time the last processing of the object finished.
+	ProcessedAt time.Time `json:"processed_at"`
+}
+
+type slowObjectKey struct {
+	gvk schema.GroupVersionKind
+	ctrl.QueueKey
+}
+
+// slowObjects tracks a bounded number of objects that took longest to process. The last processing
+// duration of each tracked object is kept so an object drops out once it is processed faster than others.
+type slowObjects struct {
+	size int
+
+	mu      sync.Mutex
+	objects map[slowObjectKey]SlowObject
+}
+
+// newSlowObjects returns a tracker of size slowest objects. Returns nil if size is not positive.
+func newSlowObjects(size int) *slowObjects {
+	if size <= 0 {
+		return nil
+	}
+	return &slowObjects{
+		size:    size,
+		objects: make(map[slowObjectKey]SlowObject, size),
+	}
+}
+
+// observe records the processing duration of an object. Can be called on a nil pointer.
+func (s *slowObjects) observe(key gvkQueueKey, duration time.Duration, now time.Time) {
+	if s == nil {
+		return
+	}
+	k := slowObjectKey{gvk: key.gvk, QueueKey: key.QueueKey}
+	obj := SlowObject{
+		GroupKind:       key.gvk.GroupKind().String(),
+		Cluster:         key.Cluster,
+		Namespace:       key.Namespace,
+		Name:            key.Name,
+		DurationSeconds: duration.Seconds(),
+		ProcessedAt:     now,
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.objects[k]; ok || len(s.objects) < s.size {
+		s.objects[k] = obj
+		return
+	}
+	var fastestKey slowObjectKey
+	var fastest *SlowObject
+	for objKey, tracked := range s.objects {
+		tracked := tracked
+		if fastest == nil || tracked.DurationSeconds < fastest.DurationSeconds {
+			fastestKey = objKey
+			fastest = &tracked
+		}
+	}
+	if obj.DurationSeconds > fastest.DurationSeconds {
+		delete(s.objects, fastestKey)
+		s.objects[k] = obj
+	}
+}
+
+// list returns tracked objects, slowest first. Can be called on a nil pointer.
+func (s *slowObjects) list() []SlowObject {
+	if s == nil {
+		return []SlowObject{}
+	}
+	s.mu.Lock()
+	result := make([]SlowObject, 0, len(s.objects))
+	for _, obj := range s.objects {
+		result = append(result, obj)
+	}
+	s.mu.Unlock()
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].DurationSeconds > result[j].DurationSeconds
+	})
+	return result
+}
+
+// SlowObjects returns objects that took longest to process across all controllers, slowest first.
+func (g *Generic) SlowObjects() []SlowObject {
+	return g.slowObjects.list()
+}
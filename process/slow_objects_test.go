@@ -0,0 +1,80 @@
+package process
+
+import (
+	"testing"
+	"time"
+
+	"github.com/atlassian/ctrl"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func slowObjectKeyFor(name string) gvkQueueKey {
+	return gvkQueueKey{
+		gvk:      configMapGvk,
+		QueueKey: ctrl.QueueKey{Namespace: "ns", Name: name},
+	}
+}
+
+func slowObjectNames(objects []SlowObject) []string {
+	names := make([]string, 0, len(objects))
+	for _, obj := range objects {
+		names = append(names, obj.Name)
+	}
+	return names
+}
+
+func TestSlowObjectsKeepsSlowest(t *testing.T) {
+	t.Parallel()
+
+	s := newSlowObjects(2)
+	now := time.Now()
+	s.observe(slowObjectKeyFor("a"), 1*time.Second, now)
+	s.observe(slowObjectKeyFor("b"), 3*time.Second, now)
+	s.observe(slowObjectKeyFor("c"), 2*time.Second, now)
+	// Faster than all tracked objects
+	s.observe(slowObjectKeyFor("d"), 500*time.Millisecond, now)
+
+	objects := s.list()
+	assert.Equal(t, []string{"b", "c"}, slowObjectNames(objects))
+	require.Len(t, objects, 2)
+	assert.Equal(t, "ConfigMap", objects[0].GroupKind)
+	assert.Equal(t, 3.0, objects[0].DurationSeconds)
+}
+
+func TestSlowObjectsUpdatesTrackedObject(t *testing.T) {
+	t.Parallel()
+
+	s := newSlowObjects(2)
+	now := time.Now()
+	s.observe(slowObjectKeyFor("a"), 5*time.Second, now)
+	s.observe(slowObjectKeyFor("b"), 3*time.Second, now)
+	// a is now fast and is replaced by the next slow object
+	s.observe(slowObjectKeyFor("a"), 10*time.Millisecond, now)
+	s.observe(slowObjectKeyFor("c"), 1*time.Second, now)
+
+	assert.Equal(t, []string{"b", "c"}, slowObjectNames(s.list()))
+}
+
+func TestSlowObjectsDisabled(t *testing.T) {
+	t.Parallel()
+
+	s := newSlowObjects(0)
+	s.observe(slowObjectKeyFor("a"), time.Second, time.Now())
+	assert.Empty(t, s.list())
+}
+
+func TestObjectLabelsFollowCardinality(t *testing.T) {
+	t.Parallel()
+
+	key := ctrl.QueueKey{Namespace: "ns", Name: "cm"}
+	for cardinality, expected := range map[ctrl.MetricsCardinality][2]string{
+		ctrl.MetricsCardinalityObject:    {"ns", "cm"},
+		ctrl.MetricsCardinalityNamespace: {"ns", ""},
+		ctrl.MetricsCardinalityGVK:       {"", ""},
+	} {
+		holder := Holder{metricsCardinality: cardinality}
+		namespace, name := holder.objectLabels(key)
+		assert.Equal(t, expected, [2]string{namespace, name}, cardinality)
+	}
+}
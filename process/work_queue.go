@@ -2,9 +2,11 @@ package process
 
 import (
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/atlassian/ctrl"
+	"github.com/prometheus/client_golang/prometheus"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/client-go/util/workqueue"
 )
@@ -15,61 +17,273 @@ type gvkQueueKey struct {
 }
 
 func (g *gvkQueueKey) String() string {
+	if g.Cluster != "" {
+		return fmt.Sprintf("%s, C=%s, Ns=%s, N=%s", g.gvk, g.Cluster, g.Namespace, g.Name)
+	}
 	return fmt.Sprintf("%s, Ns=%s, N=%s", g.gvk, g.Namespace, g.Name)
 }
 
-// workQueue is a type safe wrapper around workqueue.RateLimitingInterface.
+// queueMetrics are metrics of a single work queue.
+type queueMetrics struct {
+	adds          prometheus.Counter
+	retries       prometheus.Counter
+	queueDuration prometheus.Observer
+	workDuration  prometheus.Observer
+}
+
+// workQueue is a type safe wrapper around workqueue.DelayingInterface with rate limiting.
+// The underlying queue can be replaced with a new one once it has been shut down.
+// The rate limiter is shared by all underlying queues.
 type workQueue struct {
-	// Objects that need to be synced.
-	queue                   workqueue.RateLimitingInterface
+	rateLimiter             workqueue.RateLimiter
+	newQueue                func() workqueue.DelayingInterface
 	workDeduplicationPeriod time.Duration
+	metrics                 queueMetrics
+
+	mu sync.RWMutex
+	// Objects that need to be synced.
+	queue workqueue.DelayingInterface
+	// owns returns true if the key is owned by this replica. All keys are owned if nil.
+	owns func(ctrl.QueueKey) bool
+
+	timesMu sync.Mutex
+	// queuedSince holds the earliest time each queued key became (or will become) eligible for processing.
+	queuedSince map[gvkQueueKey]time.Time
+	// processingSince holds the time each key that is being processed was taken off the queue.
+	processingSince map[gvkQueueKey]time.Time
+	// causes hold the earliest event that caused each key to be enqueued since it was last taken off the queue.
+	causes map[gvkQueueKey]ctrl.EnqueueCause
+}
+
+func newWorkQueue(rateLimiter workqueue.RateLimiter, newQueue func() workqueue.DelayingInterface, workDeduplicationPeriod time.Duration, metrics queueMetrics) *workQueue {
+	return &workQueue{
+		rateLimiter:             rateLimiter,
+		newQueue:                newQueue,
+		workDeduplicationPeriod: workDeduplicationPeriod,
+		metrics:                 metrics,
+		queue:                   newQueue(),
+		queuedSince:             make(map[gvkQueueKey]time.Time),
+		processingSince:         make(map[gvkQueueKey]time.Time),
+		causes:                  make(map[gvkQueueKey]ctrl.EnqueueCause),
+	}
+}
+
+func (q *workQueue) current() workqueue.DelayingInterface {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	return q.queue
+}
+
+// restart replaces the queue that has been shut down with a new one.
+// Must not be called while there are workers using the queue.
+func (q *workQueue) restart() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.queue = q.newQueue()
+	q.timesMu.Lock()
+	defer q.timesMu.Unlock()
+	q.queuedSince = make(map[gvkQueueKey]time.Time)
+	q.processingSince = make(map[gvkQueueKey]time.Time)
+	q.causes = make(map[gvkQueueKey]ctrl.EnqueueCause)
+}
+
+func (q *workQueue) setOwns(owns func(ctrl.QueueKey) bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.owns = owns
+}
+
+// isOwned returns true if the key is owned by this replica.
+func (q *workQueue) isOwned(key ctrl.QueueKey) bool {
+	q.mu.RLock()
+	owns := q.owns
+	q.mu.RUnlock()
+	return owns == nil || owns(key)
 }
 
 func (q *workQueue) shutDown() {
-	q.queue.ShutDown()
+	q.current().ShutDown()
 }
 
 func (q *workQueue) get() (item gvkQueueKey, shutdown bool) {
-	i, s := q.queue.Get()
+	i, s := q.current().Get()
 	if s {
 		return gvkQueueKey{}, true
 	}
-	return i.(gvkQueueKey), false
+	item = i.(gvkQueueKey)
+	now := time.Now()
+	q.timesMu.Lock()
+	queuedSince, ok := q.queuedSince[item]
+	delete(q.queuedSince, item)
+	q.processingSince[item] = now
+	q.timesMu.Unlock()
+	if ok && now.After(queuedSince) {
+		q.metrics.queueDuration.Observe(now.Sub(queuedSince).Seconds())
+	}
+	return item, false
 }
 
 func (q *workQueue) done(item gvkQueueKey) {
-	q.queue.Done(item)
+	q.timesMu.Lock()
+	processingSince, ok := q.processingSince[item]
+	delete(q.processingSince, item)
+	q.timesMu.Unlock()
+	if ok {
+		q.metrics.workDuration.Observe(time.Since(processingSince).Seconds())
+	}
+	q.current().Done(item)
 }
 
 func (q *workQueue) forget(item gvkQueueKey) {
-	q.queue.Forget(item)
+	q.rateLimiter.Forget(item)
 }
 
 func (q *workQueue) numRequeues(item gvkQueueKey) int {
-	return q.queue.NumRequeues(item)
+	return q.rateLimiter.NumRequeues(item)
+}
+
+func (q *workQueue) add(item gvkQueueKey) {
+	q.addAfter(item, q.workDeduplicationPeriod)
 }
 
 func (q *workQueue) addRateLimited(item gvkQueueKey) {
-	q.queue.AddRateLimited(item)
+	q.metrics.retries.Inc()
+	q.addAfter(item, q.rateLimiter.When(item))
+}
+
+func (q *workQueue) addAfter(item gvkQueueKey, duration time.Duration) {
+	q.metrics.adds.Inc()
+	eligibleAt := time.Now().Add(duration)
+	q.timesMu.Lock()
+	if queuedSince, ok := q.queuedSince[item]; !ok || eligibleAt.Before(queuedSince) {
+		q.queuedSince[item] = eligibleAt
+	}
+	q.timesMu.Unlock()
+	q.current().AddAfter(item, duration)
+}
+
+// addWithCause adds the key to the queue and records the cause if it is earlier than the recorded one.
+func (q *workQueue) addWithCause(item gvkQueueKey, cause ctrl.EnqueueCause) {
+	q.setCause(item, cause)
+	q.add(item)
+}
+
+func (q *workQueue) setCause(item gvkQueueKey, cause ctrl.EnqueueCause) {
+	q.timesMu.Lock()
+	defer q.timesMu.Unlock()
+	if existing, ok := q.causes[item]; !ok || cause.Time.Before(existing.Time) {
+		q.causes[item] = cause
+	}
+}
+
+// takeCause returns and forgets the recorded cause of the key.
+func (q *workQueue) takeCause(item gvkQueueKey) (ctrl.EnqueueCause, bool) {
+	q.timesMu.Lock()
+	defer q.timesMu.Unlock()
+	cause, ok := q.causes[item]
+	delete(q.causes, item)
+	return cause, ok
+}
+
+// len returns the number of keys that are ready to be processed.
+func (q *workQueue) len() int {
+	return q.current().Len()
+}
+
+// processingStats returns the total number of seconds that keys that are being processed have been processed for
+// and the number of seconds the longest running key has been processed for.
+func (q *workQueue) processingStats(now time.Time) (float64 /* unfinished */, float64 /* longest */) {
+	q.timesMu.Lock()
+	defer q.timesMu.Unlock()
+	var unfinished, longest float64
+	for _, since := range q.processingSince {
+		duration := now.Sub(since).Seconds()
+		unfinished += duration
+		if duration > longest {
+			longest = duration
+		}
+	}
+	return unfinished, longest
 }
 
 func (q *workQueue) newQueueForGvk(gvk schema.GroupVersionKind) *gvkQueue {
 	return &gvkQueue{
-		queue:                   q.queue,
-		gvk:                     gvk,
-		workDeduplicationPeriod: q.workDeduplicationPeriod,
+		queue: q,
+		gvk:   gvk,
 	}
 }
 
 type gvkQueue struct {
-	queue                   workqueue.RateLimitingInterface
-	gvk                     schema.GroupVersionKind
-	workDeduplicationPeriod time.Duration
+	queue *workQueue
+	gvk   schema.GroupVersionKind
 }
 
+// Add adds the key to the queue if it is owned by this replica.
 func (q *gvkQueue) Add(item ctrl.QueueKey) {
-	q.queue.AddAfter(gvkQueueKey{
+	if !q.queue.isOwned(item) {
+		return
+	}
+	q.queue.add(gvkQueueKey{
 		gvk:      q.gvk,
 		QueueKey: item,
-	}, q.workDeduplicationPeriod)
+	})
+}
+
+// AddWithCause adds the key to the queue if it is owned by this replica and records the cause.
+func (q *gvkQueue) AddWithCause(item ctrl.QueueKey, cause ctrl.EnqueueCause) {
+	if !q.queue.isOwned(item) {
+		return
+	}
+	q.queue.addWithCause(gvkQueueKey{
+		gvk:      q.gvk,
+		QueueKey: item,
+	}, cause)
+}
+
+// workQueueCollector exports metrics about the state of controllers' work queues.
+type workQueueCollector struct {
+	holders    map[schema.GroupVersionKind]Holder
+	depth      *prometheus.Desc
+	unfinished *prometheus.Desc
+	longest    *prometheus.Desc
+}
+
+func newWorkQueueCollector(holders map[schema.GroupVersionKind]Holder) *workQueueCollector {
+	labels := []string{"controller", "groupkind"}
+	return &workQueueCollector{
+		holders: holders,
+		depth: prometheus.NewDesc(
+			prometheus.BuildFQName(metricsNamespace, "workqueue", "depth"),
+			"Number of objects waiting in the work queue to be processed",
+			labels, nil,
+		),
+		unfinished: prometheus.NewDesc(
+			prometheus.BuildFQName(metricsNamespace, "workqueue", "unfinished_work_seconds"),
+			"Total number of seconds objects that are being processed have been processed for. "+
+				"Large values indicate stuck workers",
+			labels, nil,
+		),
+		longest: prometheus.NewDesc(
+			prometheus.BuildFQName(metricsNamespace, "workqueue", "longest_running_processor_seconds"),
+			"Number of seconds the longest running worker has been processing its object for",
+			labels, nil,
+		),
+	}
+}
+
+func (c *workQueueCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.depth
+	ch <- c.unfinished
+	ch <- c.longest
+}
+
+func (c *workQueueCollector) Collect(ch chan<- prometheus.Metric) {
+	now := time.Now()
+	for gvk, holder := range c.holders {
+		labels := []string{holder.AppName, gvk.GroupKind().String()}
+		unfinished, longest := holder.queue.processingStats(now)
+		ch <- prometheus.MustNewConstMetric(c.depth, prometheus.GaugeValue, float64(holder.queue.len()), labels...)
+		ch <- prometheus.MustNewConstMetric(c.unfinished, prometheus.GaugeValue, unfinished, labels...)
+		ch <- prometheus.MustNewConstMetric(c.longest, prometheus.GaugeValue, longest, labels...)
+	}
 }
@@ -0,0 +1,125 @@
+package process
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/atlassian/ctrl"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/workqueue"
+)
+
+func newTestWorkQueue() (*workQueue, queueMetrics) {
+	metrics := queueMetrics{
+		adds:          prometheus.NewCounter(prometheus.CounterOpts{Name: "adds"}),
+		retries:       prometheus.NewCounter(prometheus.CounterOpts{Name: "retries"}),
+		queueDuration: prometheus.NewHistogram(prometheus.HistogramOpts{Name: "queue_duration"}),
+		workDuration:  prometheus.NewHistogram(prometheus.HistogramOpts{Name: "work_duration"}),
+	}
+	return newWorkQueue(workqueue.NewItemExponentialFailureRateLimiter(time.Millisecond, time.Second), func() workqueue.DelayingInterface {
+		return workqueue.NewDelayingQueue()
+	}, 0, metrics), metrics
+}
+
+func sampleCount(t *testing.T, observer prometheus.Observer) uint64 {
+	var m dto.Metric
+	require.NoError(t, observer.(prometheus.Metric).Write(&m))
+	return m.GetHistogram().GetSampleCount()
+}
+
+func TestWorkQueueMetrics(t *testing.T) {
+	t.Parallel()
+
+	q, metrics := newTestWorkQueue()
+	defer q.shutDown()
+	key := gvkQueueKey{
+		gvk:      configMapGvk,
+		QueueKey: ctrl.QueueKey{Namespace: "ns", Name: "cm"},
+	}
+
+	// Duplicate adds are counted but the key is queued and observed once
+	q.add(key)
+	q.add(key)
+	item, shutdown := q.get()
+	require.False(t, shutdown)
+	assert.Equal(t, key, item)
+	assert.EqualValues(t, 2, testutil.ToFloat64(metrics.adds))
+	assert.EqualValues(t, 1, sampleCount(t, metrics.queueDuration))
+	assert.EqualValues(t, 0, sampleCount(t, metrics.workDuration))
+
+	time.Sleep(10 * time.Millisecond)
+	unfinished, longest := q.processingStats(time.Now())
+	assert.True(t, unfinished >= 0.01)
+	assert.Equal(t, unfinished, longest)
+
+	q.addRateLimited(item)
+	q.done(item)
+	assert.EqualValues(t, 1, testutil.ToFloat64(metrics.retries))
+	assert.EqualValues(t, 3, testutil.ToFloat64(metrics.adds))
+	assert.EqualValues(t, 1, sampleCount(t, metrics.workDuration))
+	assert.Equal(t, 1, q.numRequeues(item))
+	unfinished, _ = q.processingStats(time.Now())
+	assert.Zero(t, unfinished)
+
+	item, shutdown = q.get()
+	require.False(t, shutdown)
+	assert.EqualValues(t, 2, sampleCount(t, metrics.queueDuration))
+	q.forget(item)
+	q.done(item)
+	assert.Zero(t, q.numRequeues(item))
+}
+
+func TestWorkQueueCollector(t *testing.T) {
+	t.Parallel()
+
+	q, _ := newTestWorkQueue()
+	defer q.shutDown()
+	q.addAfter(gvkQueueKey{gvk: configMapGvk, QueueKey: ctrl.QueueKey{Namespace: "ns", Name: "a"}}, 0)
+	q.addAfter(gvkQueueKey{gvk: configMapGvk, QueueKey: ctrl.QueueKey{Namespace: "ns", Name: "b"}}, 0)
+	// Delayed keys are not counted until they are ready
+	q.addAfter(gvkQueueKey{gvk: configMapGvk, QueueKey: ctrl.QueueKey{Namespace: "ns", Name: "c"}}, time.Hour)
+
+	registry := prometheus.NewPedanticRegistry()
+	require.NoError(t, registry.Register(newWorkQueueCollector(map[schema.GroupVersionKind]Holder{
+		configMapGvk: {AppName: "test", queue: q},
+	})))
+	expected := `
+# HELP ctrl_workqueue_depth Number of objects waiting in the work queue to be processed
+# TYPE ctrl_workqueue_depth gauge
+ctrl_workqueue_depth{controller="test",groupkind="ConfigMap"} 2
+`
+	err := wait.PollImmediate(10*time.Millisecond, 5*time.Second, func() (bool, error) {
+		return testutil.GatherAndCompare(registry, strings.NewReader(expected), "ctrl_workqueue_depth") == nil, nil
+	})
+	assert.NoError(t, err)
+}
+
+func TestWorkQueueKeepsEarliestCause(t *testing.T) {
+	t.Parallel()
+
+	q, _ := newTestWorkQueue()
+	defer q.shutDown()
+	key := gvkQueueKey{
+		gvk:      configMapGvk,
+		QueueKey: ctrl.QueueKey{Namespace: "ns", Name: "cm"},
+	}
+	now := time.Now()
+	first := ctrl.EnqueueCause{Time: now, Operation: ctrl.AddedOperation, Gvk: configMapGvk, Namespace: "ns", Name: "cm"}
+	second := ctrl.EnqueueCause{Time: now.Add(time.Second), Operation: ctrl.UpdatedOperation, Gvk: configMapGvk, Namespace: "ns", Name: "cm"}
+
+	q.newQueueForGvk(configMapGvk).AddWithCause(key.QueueKey, first)
+	q.newQueueForGvk(configMapGvk).AddWithCause(key.QueueKey, second)
+	cause, ok := q.takeCause(key)
+	require.True(t, ok)
+	assert.Equal(t, first, cause)
+	assert.Equal(t, "ConfigMap ns/cm was added", cause.String())
+	_, ok = q.takeCause(key)
+	assert.False(t, ok)
+}
@@ -0,0 +1,58 @@
+package ctrl
+
+import (
+	"github.com/pkg/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// Selector restricts the set of objects that an informer watches and caches.
+type Selector struct {
+	// LabelSelector is a label selector e.g. "app=foo,tier!=db". Optional.
+	LabelSelector string
+	// FieldSelector is a field selector e.g. "type=kubernetes.io/tls". Optional.
+	FieldSelector string
+}
+
+// IsEmpty returns true if the selector selects all objects.
+func (s Selector) IsEmpty() bool {
+	return s.LabelSelector == "" && s.FieldSelector == ""
+}
+
+// Validate returns an error if any of the selectors cannot be parsed.
+func (s Selector) Validate() error {
+	if _, err := labels.Parse(s.LabelSelector); err != nil {
+		return errors.Wrapf(err, "invalid label selector %q", s.LabelSelector)
+	}
+	if _, err := fields.ParseSelector(s.FieldSelector); err != nil {
+		return errors.Wrapf(err, "invalid field selector %q", s.FieldSelector)
+	}
+	return nil
+}
+
+// And returns a selector that selects objects that are selected by both s and other.
+func (s Selector) And(other Selector) Selector {
+	return Selector{
+		LabelSelector: joinSelectors(s.LabelSelector, other.LabelSelector),
+		FieldSelector: joinSelectors(s.FieldSelector, other.FieldSelector),
+	}
+}
+
+// ApplyTo restricts list options to objects that are selected by the selector.
+func (s Selector) ApplyTo(options *meta_v1.ListOptions) {
+	options.LabelSelector = joinSelectors(options.LabelSelector, s.LabelSelector)
+	options.FieldSelector = joinSelectors(options.FieldSelector, s.FieldSelector)
+}
+
+// joinSelectors joins label or field selectors. All requirements of both selectors must match.
+func joinSelectors(a, b string) string {
+	switch {
+	case a == "":
+		return b
+	case b == "":
+		return a
+	default:
+		return a + "," + b
+	}
+}
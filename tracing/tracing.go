@@ -0,0 +1,159 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	chimw "github.com/go-chi/chi/middleware"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+	"k8s.io/client-go/transport"
+)
+
+const (
+	// TracerName is the name of the tracer that creates all spans of ctrl.
+	TracerName = "github.com/atlassian/ctrl"
+)
+
+// Attributes of spans.
+const (
+	ControllerKey = attribute.Key("ctrl.controller")
+	GvkKey        = attribute.Key("ctrl.gvk")
+	GroupKindKey  = attribute.Key("ctrl.groupkind")
+	ClusterKey    = attribute.Key("ctrl.cluster")
+	NamespaceKey  = attribute.Key("ctrl.object.namespace")
+	NameKey       = attribute.Key("ctrl.object.name")
+	// OutcomeKey is the outcome of processing of an object.
+	OutcomeKey = attribute.Key("ctrl.outcome")
+)
+
+// Outcomes of processing of an object.
+const (
+	OutcomeSuccess        = "success"
+	OutcomeRequeue        = "requeue"
+	OutcomeRetriableError = "retriable_error"
+	OutcomeError          = "error"
+)
+
+// propagator propagates the trace context in W3C Trace Context headers.
+var propagator = propagation.TraceContext{}
+
+// Tracer returns the tracer of ctrl from the provider. A no-op tracer is returned if the provider is nil.
+func Tracer(tp trace.TracerProvider) trace.Tracer {
+	if tp == nil {
+		tp = trace.NewNoopTracerProvider()
+	}
+	return tp.Tracer(TracerName)
+}
+
+// Transport returns a wrapper for rest.Config.WrapTransport that creates a client span for requests to
+// the Kubernetes API. Spans are only created for requests that carry a span in their context, either
+// because the request was made with a context (rest.Request.Context()) or because its client was constructed
+// with ContextTransport. List and watch requests of informers are not traced.
+func Transport(tp trace.TracerProvider) transport.WrapperFunc {
+	tracer := Tracer(tp)
+	return func(rt http.RoundTripper) http.RoundTripper {
+		return &roundTripper{
+			tracer: tracer,
+			next:   rt,
+		}
+	}
+}
+
+type roundTripper struct {
+	tracer trace.Tracer
+	next   http.RoundTripper
+}
+
+func (t *roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !trace.SpanContextFromContext(req.Context()).IsValid() {
+		return t.next.RoundTrip(req)
+	}
+	ctx, span := t.tracer.Start(req.Context(), "HTTP "+req.Method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.HTTPMethodKey.String(req.Method),
+			semconv.HTTPURLKey.String(req.URL.String()),
+		))
+	// Span ends once response headers have been received, reading the body is not included
+	defer span.End()
+
+	// RoundTripper must not modify the original request
+	req = req.Clone(ctx)
+	propagator.Inject(ctx, propagation.HeaderCarrier(req.Header))
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	span.SetAttributes(semconv.HTTPStatusCodeKey.Int(resp.StatusCode))
+	if resp.StatusCode >= http.StatusBadRequest {
+		span.SetStatus(codes.Error, strconv.Itoa(resp.StatusCode)+" "+http.StatusText(resp.StatusCode))
+	}
+	return resp, nil
+}
+
+// ContextTransport returns a wrapper for rest.Config.WrapTransport that makes requests carry the span of ctx.
+// Clients of this version of client-go do not accept a context, so clients that are constructed with this
+// wrapper are the way to trace their requests as children of the span. Requests that carry a span already are
+// not changed. The wrapper must be applied after Transport so that Transport sees the span.
+func ContextTransport(ctx context.Context) transport.WrapperFunc {
+	span := trace.SpanFromContext(ctx)
+	return func(rt http.RoundTripper) http.RoundTripper {
+		return &contextRoundTripper{
+			span: span,
+			next: rt,
+		}
+	}
+}
+
+type contextRoundTripper struct {
+	span trace.Span
+	next http.RoundTripper
+}
+
+func (t *contextRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !trace.SpanContextFromContext(req.Context()).IsValid() {
+		req = req.WithContext(trace.ContextWithSpan(req.Context(), t.span))
+	}
+	return t.next.RoundTrip(req)
+}
+
+// Middleware returns an HTTP middleware that creates a server span for each request. The span is a child
+// of the span propagated in the request's W3C Trace Context headers, if any. attrs are added to each span.
+func Middleware(tp trace.TracerProvider, attrs ...attribute.KeyValue) func(http.Handler) http.Handler {
+	tracer := Tracer(tp)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+			spanAttrs := make([]attribute.KeyValue, 0, len(attrs)+2)
+			spanAttrs = append(spanAttrs, attrs...)
+			spanAttrs = append(spanAttrs,
+				semconv.HTTPMethodKey.String(r.Method),
+				semconv.HTTPTargetKey.String(r.URL.Path),
+			)
+			ctx, span := tracer.Start(ctx, "HTTP "+r.Method,
+				trace.WithSpanKind(trace.SpanKindServer),
+				trace.WithAttributes(spanAttrs...))
+			defer span.End()
+
+			res := chimw.NewWrapResponseWriter(w, r.ProtoMajor)
+			next.ServeHTTP(res, r.WithContext(ctx))
+
+			status := res.Status()
+			if status == 0 {
+				// Handler has not written anything
+				status = http.StatusOK
+			}
+			span.SetAttributes(semconv.HTTPStatusCodeKey.Int(status))
+			if status >= http.StatusInternalServerError {
+				span.SetStatus(codes.Error, strconv.Itoa(status)+" "+http.StatusText(status))
+			}
+		})
+	}
+}
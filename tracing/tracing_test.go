@@ -0,0 +1,125 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func newTestTracerProvider() (*sdktrace.TracerProvider, *tracetest.InMemoryExporter) {
+	exporter := tracetest.NewInMemoryExporter()
+	return sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)), exporter
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestTransportCreatesChildSpans(t *testing.T) {
+	t.Parallel()
+
+	tp, exporter := newTestTracerProvider()
+	var traceParent string
+	rt := Transport(tp)(roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		traceParent = req.Header.Get("traceparent")
+		return &http.Response{StatusCode: http.StatusNotFound}, nil
+	}))
+
+	// Request without a parent span is not traced
+	req := httptest.NewRequest(http.MethodGet, "https://kube-api/api/v1/namespaces/ns/configmaps/cm", nil)
+	_, err := rt.RoundTrip(req)
+	require.NoError(t, err)
+	assert.Empty(t, traceParent)
+	assert.Empty(t, exporter.GetSpans())
+
+	ctx, parent := tp.Tracer("test").Start(context.Background(), "parent")
+	_, err = rt.RoundTrip(req.WithContext(ctx))
+	require.NoError(t, err)
+	parent.End()
+
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 2)
+	span := spans[0]
+	assert.Equal(t, "HTTP GET", span.Name)
+	assert.Equal(t, trace.SpanKindClient, span.SpanKind)
+	assert.Equal(t, parent.SpanContext().SpanID(), span.Parent.SpanID())
+	assert.Contains(t, span.Attributes, semconv.HTTPStatusCodeKey.Int(http.StatusNotFound))
+	assert.Equal(t, codes.Error, span.Status.Code)
+	assert.Contains(t, traceParent, span.SpanContext.SpanID().String())
+	assert.Empty(t, req.Header.Get("traceparent"), "original request must not be modified")
+}
+
+func TestContextTransportMakesRequestsChildrenOfSpan(t *testing.T) {
+	t.Parallel()
+
+	tp, exporter := newTestTracerProvider()
+	ctx, parent := tp.Tracer("test").Start(context.Background(), "parent")
+	var requestSpan trace.SpanContext
+	next := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		requestSpan = trace.SpanContextFromContext(req.Context())
+		return &http.Response{StatusCode: http.StatusOK}, nil
+	})
+	// ContextTransport is applied after Transport
+	rt := ContextTransport(ctx)(Transport(tp)(next))
+
+	req := httptest.NewRequest(http.MethodGet, "https://kube-api/api/v1/namespaces/ns/configmaps/cm", nil)
+	_, err := rt.RoundTrip(req)
+	require.NoError(t, err)
+	parent.End()
+
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 2)
+	span := spans[0]
+	assert.Equal(t, "HTTP GET", span.Name)
+	assert.Equal(t, parent.SpanContext().SpanID(), span.Parent.SpanID())
+	assert.Equal(t, span.SpanContext.SpanID(), requestSpan.SpanID())
+	assert.False(t, trace.SpanContextFromContext(req.Context()).IsValid(), "original request must not be modified")
+
+	// Span of the request takes precedence
+	otherCtx, other := tp.Tracer("test").Start(context.Background(), "other")
+	_, err = rt.RoundTrip(req.WithContext(otherCtx))
+	require.NoError(t, err)
+	assert.Equal(t, other.SpanContext().TraceID(), requestSpan.TraceID())
+}
+
+func TestMiddlewareCreatesServerSpans(t *testing.T) {
+	t.Parallel()
+
+	tp, exporter := newTestTracerProvider()
+	var handlerSpan trace.SpanContext
+	handler := Middleware(tp, ControllerKey.String("test"))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handlerSpan = trace.SpanContextFromContext(r.Context())
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/hook", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 1)
+	span := spans[0]
+	assert.Equal(t, "HTTP POST", span.Name)
+	assert.Equal(t, trace.SpanKindServer, span.SpanKind)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", span.SpanContext.TraceID().String())
+	assert.Equal(t, "00f067aa0ba902b7", span.Parent.SpanID().String())
+	assert.Equal(t, span.SpanContext.SpanID(), handlerSpan.SpanID())
+	assert.Subset(t, span.Attributes, []attribute.KeyValue{
+		ControllerKey.String("test"),
+		semconv.HTTPTargetKey.String("/hook"),
+		semconv.HTTPStatusCodeKey.Int(http.StatusInternalServerError),
+	})
+	assert.Equal(t, codes.Error, span.Status.Code)
+}
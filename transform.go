@@ -0,0 +1,102 @@
+package ctrl
+
+import (
+	"k8s.io/apimachinery/pkg/api/meta"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+)
+
+// TransformFunc mutates an object before it is stored in an informer's cache.
+// It is used to drop fields that controllers do not need to reduce memory usage.
+type TransformFunc func(obj runtime.Object)
+
+// StripManagedFields is a TransformFunc that removes managed fields from objects.
+func StripManagedFields(obj runtime.Object) {
+	if metaObj, err := meta.Accessor(obj); err == nil {
+		metaObj.SetManagedFields(nil)
+	}
+}
+
+// StripAnnotations returns a TransformFunc that removes annotations with the keys from objects.
+func StripAnnotations(keys ...string) TransformFunc {
+	return func(obj runtime.Object) {
+		metaObj, err := meta.Accessor(obj)
+		if err != nil {
+			return
+		}
+		annotations := metaObj.GetAnnotations()
+		if len(annotations) == 0 {
+			return
+		}
+		for _, key := range keys {
+			delete(annotations, key)
+		}
+		metaObj.SetAnnotations(annotations)
+	}
+}
+
+// ChainTransforms returns a TransformFunc that applies transforms in order. Nil transforms are skipped.
+// Returns nil if there are no transforms to apply.
+func ChainTransforms(transforms ...TransformFunc) TransformFunc {
+	var chain []TransformFunc
+	for _, transform := range transforms {
+		if transform != nil {
+			chain = append(chain, transform)
+		}
+	}
+	if len(chain) == 0 {
+		return nil
+	}
+	return func(obj runtime.Object) {
+		for _, transform := range chain {
+			transform(obj)
+		}
+	}
+}
+
+// NewTransformingListWatch returns a ListerWatcher that applies transform to all listed and watched objects.
+func NewTransformingListWatch(lw cache.ListerWatcher, transform TransformFunc) cache.ListerWatcher {
+	if transform == nil {
+		return lw
+	}
+	return &transformingListWatch{
+		lw:        lw,
+		transform: transform,
+	}
+}
+
+type transformingListWatch struct {
+	lw        cache.ListerWatcher
+	transform TransformFunc
+}
+
+func (t *transformingListWatch) List(options meta_v1.ListOptions) (runtime.Object, error) {
+	list, err := t.lw.List(options)
+	if err != nil {
+		return nil, err
+	}
+	// Items are extracted as pointers into the list, they are mutated in place
+	items, err := meta.ExtractList(list)
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range items {
+		t.transform(item)
+	}
+	return list, nil
+}
+
+func (t *transformingListWatch) Watch(options meta_v1.ListOptions) (watch.Interface, error) {
+	w, err := t.lw.Watch(options)
+	if err != nil {
+		return nil, err
+	}
+	return watch.Filter(w, func(event watch.Event) (watch.Event, bool) {
+		if event.Type != watch.Error && event.Object != nil {
+			t.transform(event.Object)
+		}
+		return event, true
+	}), nil
+}
@@ -0,0 +1,79 @@
+package ctrl
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/metadata/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestMetadataInformerTransformsObjects(t *testing.T) {
+	t.Parallel()
+
+	gvk := schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"}
+	scheme := runtime.NewScheme()
+	require.NoError(t, meta_v1.AddMetaToScheme(scheme))
+	newObj := func(name string) *meta_v1.PartialObjectMetadata {
+		return &meta_v1.PartialObjectMetadata{
+			TypeMeta: meta_v1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"},
+			ObjectMeta: meta_v1.ObjectMeta{
+				Namespace:     "ns",
+				Name:          name,
+				Annotations:   map[string]string{"large": "x", "small": "y"},
+				ManagedFields: []meta_v1.ManagedFieldsEntry{{Manager: "m"}},
+			},
+		}
+	}
+	client := fake.NewSimpleMetadataClient(scheme, newObj("listed"))
+
+	cctx := &Context{}
+	inf, err := cctx.MainMetadataInformer(&Config{
+		Namespace:         "ns",
+		ResyncPeriod:      time.Hour,
+		MetadataClient:    client,
+		InformerTransform: ChainTransforms(StripManagedFields, StripAnnotations("large")),
+	}, gvk, "configmaps")
+	require.NoError(t, err)
+	assert.Equal(t, inf, cctx.Informers[gvk])
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	go inf.Run(stopCh)
+	require.True(t, cache.WaitForCacheSync(stopCh, inf.HasSynced))
+
+	obj, exists, err := inf.GetIndexer().GetByKey("ns/listed")
+	require.NoError(t, err)
+	require.True(t, exists)
+	metaObj := obj.(*meta_v1.PartialObjectMetadata)
+	assert.Equal(t, map[string]string{"small": "y"}, metaObj.Annotations)
+	assert.Empty(t, metaObj.ManagedFields)
+}
+
+func TestTransformingListWatchTransformsWatchedObjects(t *testing.T) {
+	t.Parallel()
+
+	fakeWatch := watch.NewFake()
+	lw := NewTransformingListWatch(&cache.ListWatch{
+		WatchFunc: func(options meta_v1.ListOptions) (watch.Interface, error) {
+			return fakeWatch, nil
+		},
+	}, StripAnnotations("large"))
+	w, err := lw.Watch(meta_v1.ListOptions{})
+	require.NoError(t, err)
+	defer w.Stop()
+
+	go fakeWatch.Add(&meta_v1.PartialObjectMetadata{
+		ObjectMeta: meta_v1.ObjectMeta{
+			Annotations: map[string]string{"large": "x", "small": "y"},
+		},
+	})
+	event := <-w.ResultChan()
+	assert.Equal(t, map[string]string{"small": "y"}, event.Object.(*meta_v1.PartialObjectMetadata).Annotations)
+}
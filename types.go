@@ -5,14 +5,26 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/atlassian/ctrl/healthz"
+	"github.com/atlassian/ctrl/loglevel"
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/api/meta"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/informers/internalinterfaces"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/metadata"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
 )
 
 type FlagSet interface {
@@ -29,6 +41,62 @@ type FlagSet interface {
 type Descriptor struct {
 	// Group Version Kind of objects a controller can process.
 	Gvk schema.GroupVersionKind
+	// Workers is the number of workers that process objects from the controller's work queue.
+	// Optional. The default number of workers is used if zero.
+	Workers uint
+	// RateLimiter is used by the controller's work queue to rate limit retries.
+	// Optional. If nil, a rate limiter is constructed from the RetryPolicy.
+	RateLimiter workqueue.RateLimiter
+	// RetryPolicy controls how objects are retried after retriable errors.
+	// Optional. Zero fields are set to defaults.
+	RetryPolicy RetryPolicy
+	// ProcessTimeout is the maximum duration of processing of a single object.
+	// Optional. Config.ProcessTimeout is used if zero.
+	ProcessTimeout time.Duration
+	// AddToScheme registers types of the GVK with the scheme used by the controller's event recorder.
+	// Optional. Required if the GVK is not a built-in Kubernetes type.
+	AddToScheme func(*runtime.Scheme) error
+	// SeparateLeaderElection makes the controller contend for leadership using its own leader election lock
+	// rather than the lock shared by the other controllers, so that different replicas can lead different controllers.
+	// Only applicable if leader election is enabled.
+	SeparateLeaderElection bool
+	// Selector restricts objects that are watched by the main informer for Gvk. It is combined with
+	// Config.InformerSelector. Optional.
+	Selector Selector
+}
+
+// NoRetries is the value of RetryPolicy.MaxRetries that makes the work queue drop objects after
+// the first failure.
+const NoRetries = -1
+
+// RetryPolicy controls how objects that failed processing with a retriable error are retried.
+type RetryPolicy struct {
+	// MaxRetries is the number of times an object is retried before it is dropped out of the queue.
+	// The default number of retries is used if zero. Set to NoRetries to disable retries.
+	MaxRetries int
+	// RetryForever makes the work queue retry objects until they are processed successfully.
+	// MaxRetries is ignored if set.
+	RetryForever bool
+	// BaseDelay is the delay before the first retry. Each subsequent retry doubles the delay.
+	// Ignored if Descriptor.RateLimiter is set.
+	BaseDelay time.Duration
+	// MaxDelay is the maximum delay between retries.
+	// Ignored if Descriptor.RateLimiter is set.
+	MaxDelay time.Duration
+	// Jitter is a non-negative factor. Each delay is increased by a random duration of up to delay*Jitter.
+	// Ignored if Descriptor.RateLimiter is set.
+	Jitter float64
+}
+
+// RetryPolicyOverride overrides fields of retry policies of controllers. Only non-nil fields are applied.
+type RetryPolicyOverride struct {
+	// MaxRetries overrides RetryPolicy.MaxRetries. The default number of retries is used if zero.
+	// Set to NoRetries to disable retries.
+	MaxRetries   *int
+	RetryForever *bool
+	BaseDelay    *time.Duration
+	MaxDelay     *time.Duration
+	Jitter       *float64
 }
 
 type Server interface {
@@ -40,6 +108,23 @@ type Constructed struct {
 	Interface Interface
 	// Server holds an optional server interface.
 	Server Server
+	// Finalizer holds an optional finalizer that is managed for objects the controller processes.
+	// Requires Interface to be set.
+	Finalizer *Finalizer
+}
+
+// Finalizer is added to objects the controller processes before they are passed to Process.
+// Once an object is marked for deletion, it is passed to Cleanup instead of Process and the
+// finalizer is removed when Cleanup succeeds.
+type Finalizer struct {
+	// Name is the name of the finalizer e.g. "example.com/cleanup".
+	Name string
+	// Resource is the name of the resource of the controller's GVK e.g. "deployments".
+	// It is used to update the objects' finalizers. Optional if Config.RESTMapper is set.
+	Resource string
+	// Cleanup is called for objects that have been marked for deletion and still have the finalizer.
+	// The finalizer is removed once Cleanup returns no error and does not ask for the object to be requeued.
+	Cleanup func(*ProcessContext) (ProcessResult, error)
 }
 
 type Constructor interface {
@@ -54,42 +139,204 @@ type Constructor interface {
 type Interface interface {
 	Run(context.Context)
 
+	// Process is implemented by the controller and returns:
+	// - a result that tells if the object should be added back to the work queue
+	//   even though it was processed successfully. Ignored if there is an error.
+	// - an error, if there is an error, or nil. Errors can be classified as external
+	//   and/or retriable using ProcessError. Errors that are not classified are treated
+	//   as internal, non-retriable errors.
+	Process(*ProcessContext) (ProcessResult, error)
+}
+
+// DeletionProcessor is an optional interface that a controller can implement to be notified about objects
+// that are no longer in the informer's cache.
+type DeletionProcessor interface {
+	// ProcessDeleted is called instead of Process when an object with the key is not in the informer's cache.
+	// ProcessContext.Object holds the last known state of the object. It may be stale if the deletion was
+	// observed via a cache.DeletedFinalStateUnknown tombstone. It is nil if the last known state is not known,
+	// e.g. if the object was deleted while the process was not running.
+	ProcessDeleted(*ProcessContext, QueueKey) (ProcessResult, error)
+}
+
+// ProcessResult is the result of successful processing of an object.
+type ProcessResult struct {
+	// Requeue tells the work queue to add the object back to the queue with a rate limited delay.
+	Requeue bool
+	// RequeueAfter, if positive, tells the work queue to add the object back to the queue after the
+	// specified duration. Takes precedence over Requeue.
+	RequeueAfter time.Duration
+}
+
+// LegacyInterface is the previous form of Interface where errors are classified using a pair of bools.
+// Use LegacyAdapter to turn it into an Interface.
+type LegacyInterface interface {
+	Run(context.Context)
+
 	// Process is implemented by the controller and returns:
 	// - true for externalErr if the error is not an internal error
 	// - true for retriableErr if the error is a retriable error (i.e. should be
-	//   added back to the work queue). These are retried for limited number of
-	//   attempts
+	//   added back to the work queue). These are retried according to the
+	//   controller's RetryPolicy
 	// - an error, if there is an error, or nil. If there is no error, the above
 	//   two bools (externalErr and retriableErr) are ignored.
 	Process(*ProcessContext) (externalErr bool, retriableErr bool, err error)
 }
 
+// LegacyAdapter adapts a LegacyInterface to Interface.
+type LegacyAdapter struct {
+	LegacyInterface
+}
+
+func (a LegacyAdapter) Process(pctx *ProcessContext) (ProcessResult, error) {
+	external, retriable, err := a.LegacyInterface.Process(pctx)
+	if err != nil {
+		err = &ProcessError{
+			Err:       err,
+			External:  external,
+			Retriable: retriable,
+		}
+	}
+	return ProcessResult{}, err
+}
+
 type WorkQueueProducer interface {
 	// Add adds an item to the workqueue.
 	Add(QueueKey)
 }
 
+// EnqueueCause describes the informer event that caused an object to be enqueued.
+type EnqueueCause struct {
+	// Time when the event was received.
+	Time      time.Time
+	Operation Operation
+	// Gvk, Namespace and Name identify the object the event was for. It is a different object than
+	// the enqueued one if the enqueued object was looked up using the event's object.
+	Gvk       schema.GroupVersionKind
+	Namespace string
+	Name      string
+}
+
+func (c EnqueueCause) String() string {
+	objName := c.Name
+	if c.Namespace != "" {
+		objName = c.Namespace + "/" + c.Name
+	}
+	return c.Gvk.Kind + " " + objName + " was " + c.Operation.String()
+}
+
+// CausalWorkQueueProducer is a WorkQueueProducer that tracks why items were enqueued.
+type CausalWorkQueueProducer interface {
+	WorkQueueProducer
+	// AddWithCause adds an item to the workqueue and records the event that caused it.
+	AddWithCause(QueueKey, EnqueueCause)
+}
+
+// AddWithCause adds the item to the work queue recording the cause if the work queue supports it.
+func AddWithCause(wq WorkQueueProducer, item QueueKey, cause EnqueueCause) {
+	if causal, ok := wq.(CausalWorkQueueProducer); ok {
+		causal.AddWithCause(item, cause)
+		return
+	}
+	wq.Add(item)
+}
+
 type ProcessContext struct {
-	Logger *zap.Logger
-	Object runtime.Object
+	// Context is done when the controller is shutting down (e.g. if leadership has been lost)
+	// or when the processing timeout is exceeded.
+	// If tracing is enabled, it carries the span of processing of the object.
+	Context context.Context
+	Logger  *zap.Logger
+	Object  runtime.Object
+	// Cluster is the cluster the object came from. Its clients should be used to work with the object.
+	// If the span of processing is recorded, the clients are constructed for this ProcessContext from
+	// the cluster's RestConfig and trace their requests as children of the span.
+	Cluster Cluster
 }
 
 type QueueKey struct {
+	// Cluster is the name of the cluster the object is in. Empty for the main cluster.
+	Cluster   string
 	Namespace string
 	Name      string
 }
 
 type Config struct {
-	AppName      string
-	Logger       *zap.Logger
-	Namespace    string
-	ResyncPeriod time.Duration
-	Registry     prometheus.Registerer
+	AppName string
+	Logger  *zap.Logger
+	// LogLevels is used to construct loggers of controllers so that their levels can be changed at runtime.
+	// Logger must have been constructed by it. Optional.
+	LogLevels *loglevel.Registry
+	Namespace string
+	// Namespaces is a list of namespaces to watch. Takes precedence over Namespace if not empty.
+	Namespaces []string
+	// NamespaceSelector is a label selector for namespaces to watch in addition to Namespaces.
+	// Namespace is ignored if not empty.
+	NamespaceSelector string
+	// InformerSelector restricts objects that are watched by main informers. Informers that cannot restrict
+	// objects i.e. the ones constructed by MainInformer and MainClusterInformer cannot be used if it is set.
+	InformerSelector Selector
+	ResyncPeriod     time.Duration
+	Registry         prometheus.Registerer
+	// RetryPolicy overrides retry policies of all controllers. Only non-nil fields are applied.
+	RetryPolicy RetryPolicyOverride
+	// ProcessTimeout is the maximum duration of processing of a single object for controllers
+	// that don't specify it in their Descriptor. No timeout if zero.
+	ProcessTimeout time.Duration
+	// RecoverPanics makes workers recover panics in Process. The object is retried as if Process returned
+	// a retriable internal error.
+	RecoverPanics bool
+	// EventBroadcaster is used to construct event recorders for controllers.
+	// Optional. Events are discarded if nil.
+	EventBroadcaster record.EventBroadcaster
+	// EmitDropEvents makes workers emit a Warning event for objects that are dropped out of the work queue
+	// because of an error.
+	EmitDropEvents bool
+	// LivenessStuckThreshold is the duration after which a worker that is processing a single object is
+	// considered stuck and the controller is not live. Disabled if zero.
+	LivenessStuckThreshold time.Duration
+	// LivenessInformerEventTimeout is the maximum duration since an informer of a controller last observed
+	// a watch event after which the controller is not live. Disabled if zero. See process.HealthConfig
+	// for what counts as a watch event.
+	LivenessInformerEventTimeout time.Duration
+	// HealthChecks is the registry that health checks of controllers are registered with. Optional.
+	HealthChecks *healthz.Registry
+	// ObjectMetricsCardinality controls how objects are identified in per-object metrics.
+	// MetricsCardinalityGVK is used if empty.
+	ObjectMetricsCardinality MetricsCardinality
+	// SlowObjects is the number of objects that took longest to process to track. Disabled if zero.
+	SlowObjects int
+	// TracerProvider is used to trace processing of objects and requests to servers of controllers.
+	// Requests to the Kubernetes API are traced if RestConfig and RestConfig of Clusters are wrapped
+	// with tracing.Transport. Optional. Tracing is disabled if nil.
+	TracerProvider trace.TracerProvider
 
-	RestConfig *rest.Config
-	MainClient kubernetes.Interface
+	// InformerTransform is applied to objects before they are stored in caches of informers that were
+	// constructed using MainTransformedInformer, MultiClusterTransformedInformer and metadata informers. Optional.
+	InformerTransform TransformFunc
+
+	RestConfig    *rest.Config
+	MainClient    kubernetes.Interface
+	DynamicClient dynamic.Interface
+	// RESTMapper maps GVKs to resources. Used by dynamic informers. Optional.
+	RESTMapper meta.RESTMapper
+	// MetadataClient is used by metadata informers. Optional.
+	MetadataClient metadata.Interface
+	// Clusters are additional clusters that are watched by multi-cluster controllers. Optional.
+	Clusters []Cluster
 }
 
+// MetricsCardinality controls which labels identify objects in per-object metrics.
+type MetricsCardinality string
+
+const (
+	// MetricsCardinalityObject labels metrics with object namespace and name. Produces a series per object.
+	MetricsCardinalityObject MetricsCardinality = "object"
+	// MetricsCardinalityNamespace labels metrics with object namespace only. Produces a series per namespace.
+	MetricsCardinalityNamespace MetricsCardinality = "namespace"
+	// MetricsCardinalityGVK does not label metrics with object namespace or name. Produces a series per GVK.
+	MetricsCardinalityGVK MetricsCardinality = "gvk"
+)
+
 type Operation string
 
 const (
@@ -105,7 +352,8 @@ func (o Operation) String() string {
 type Context struct {
 	// ReadyForWork is a function that the controller must call from its Run() method once it is ready to
 	// process work using it's Process() method. This should be used to delay processing while some initialization
-	// is being performed.
+	// is being performed. Servers run on all replicas, including ones that do not run the controller because they
+	// are not leading it, so a server must not rely on the controller's Run() to call it.
 	ReadyForWork func()
 	// Middleware is the standard middleware that is supposed to be used to wrap the http handler of the server.
 	Middleware func(http.Handler) http.Handler
@@ -116,6 +364,18 @@ type Context struct {
 	// This is a read only field, must not be modified.
 	Controllers map[schema.GroupVersionKind]Interface
 	WorkQueue   WorkQueueProducer
+	// Recorder is the event recorder for the controller.
+	Recorder record.EventRecorder
+	// Descriptor of the controller that is being constructed.
+	Descriptor Descriptor
+	// Will contain selectors of all informers that were constructed with selectors.
+	// This is a read only field, must not be modified.
+	InformerSelectors map[schema.GroupVersionKind]Selector
+	// Will contain informers for additional clusters by cluster name once Generic controller constructs all controllers.
+	// This is a read only field, must not be modified.
+	ClusterInformers map[string]map[schema.GroupVersionKind]cache.SharedIndexInformer
+	// HealthChecks is the registry of health checks. Use AddLivenessCheck and AddReadinessCheck to register checks.
+	HealthChecks *healthz.Registry
 }
 
 func (c *Context) RegisterInformer(gvk schema.GroupVersionKind, inf cache.SharedIndexInformer) error {
@@ -129,10 +389,16 @@ func (c *Context) RegisterInformer(gvk schema.GroupVersionKind, inf cache.Shared
 	return nil
 }
 
+// MainInformer constructs and registers an informer for gvk in the configured namespaces. Returns an error if
+// objects should be restricted by Config.InformerSelector or the Descriptor's selector, MainFilteredInformer
+// must be used in that case.
 func (c *Context) MainInformer(config *Config, gvk schema.GroupVersionKind, f func(kubernetes.Interface, string, time.Duration, cache.Indexers) cache.SharedIndexInformer) (cache.SharedIndexInformer, error) {
 	inf := c.Informers[gvk]
 	if inf == nil {
-		inf = f(config.MainClient, config.Namespace, config.ResyncPeriod, cache.Indexers{})
+		if err := c.checkUnfilteredInformer(config, gvk, "MainFilteredInformer"); err != nil {
+			return nil, err
+		}
+		inf = newNamespacedInformer(config, config.MainClient, f)
 		err := c.RegisterInformer(gvk, inf)
 		if err != nil {
 			return nil, err
@@ -141,9 +407,24 @@ func (c *Context) MainInformer(config *Config, gvk schema.GroupVersionKind, f fu
 	return inf, nil
 }
 
+// newNamespacedInformer constructs an informer for objects in the configured namespaces using the client.
+func newNamespacedInformer(config *Config, client kubernetes.Interface, f func(kubernetes.Interface, string, time.Duration, cache.Indexers) cache.SharedIndexInformer) cache.SharedIndexInformer {
+	if len(config.Namespaces) > 0 || config.NamespaceSelector != "" {
+		return NewMultiNamespaceInformer(config.Logger, client, config.Namespaces, config.NamespaceSelector, config.ResyncPeriod, func(namespace string) cache.SharedIndexInformer {
+			return f(client, namespace, config.ResyncPeriod, cache.Indexers{})
+		})
+	}
+	return f(client, config.Namespace, config.ResyncPeriod, cache.Indexers{})
+}
+
+// MainClusterInformer constructs and registers a cluster wide informer for gvk. Selectors are handled the same
+// way as by MainInformer, MainFilteredClusterInformer must be used to restrict objects.
 func (c *Context) MainClusterInformer(config *Config, gvk schema.GroupVersionKind, f func(kubernetes.Interface, time.Duration, cache.Indexers) cache.SharedIndexInformer) (cache.SharedIndexInformer, error) {
 	inf := c.Informers[gvk]
 	if inf == nil {
+		if err := c.checkUnfilteredInformer(config, gvk, "MainFilteredClusterInformer"); err != nil {
+			return nil, err
+		}
 		inf = f(config.MainClient, config.ResyncPeriod, cache.Indexers{})
 		err := c.RegisterInformer(gvk, inf)
 		if err != nil {
@@ -152,3 +433,199 @@ func (c *Context) MainClusterInformer(config *Config, gvk schema.GroupVersionKin
 	}
 	return inf, nil
 }
+
+// MainFilteredInformer is like MainInformer but it restricts objects using Config.InformerSelector and
+// the selector from the Descriptor if gvk is the GVK of the controller. f is a function like
+// NewFilteredConfigMapInformer from k8s.io/client-go/informers/core/v1.
+func (c *Context) MainFilteredInformer(config *Config, gvk schema.GroupVersionKind, f func(kubernetes.Interface, string, time.Duration, cache.Indexers, internalinterfaces.TweakListOptionsFunc) cache.SharedIndexInformer) (cache.SharedIndexInformer, error) {
+	inf := c.Informers[gvk]
+	if inf == nil {
+		selector := c.informerSelector(config, gvk)
+		if err := selector.Validate(); err != nil {
+			return nil, err
+		}
+		inf = newFilteredNamespacedInformer(config, config.MainClient, selector, f)
+		err := c.registerFilteredInformer(gvk, inf, selector)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return inf, nil
+}
+
+// newFilteredNamespacedInformer is like newNamespacedInformer but it restricts objects using the selector.
+func newFilteredNamespacedInformer(config *Config, client kubernetes.Interface, selector Selector, f func(kubernetes.Interface, string, time.Duration, cache.Indexers, internalinterfaces.TweakListOptionsFunc) cache.SharedIndexInformer) cache.SharedIndexInformer {
+	if len(config.Namespaces) > 0 || config.NamespaceSelector != "" {
+		return NewMultiNamespaceInformer(config.Logger, client, config.Namespaces, config.NamespaceSelector, config.ResyncPeriod, func(namespace string) cache.SharedIndexInformer {
+			return f(client, namespace, config.ResyncPeriod, cache.Indexers{}, selector.ApplyTo)
+		})
+	}
+	return f(client, config.Namespace, config.ResyncPeriod, cache.Indexers{}, selector.ApplyTo)
+}
+
+// MainFilteredClusterInformer is like MainClusterInformer but it restricts objects the same way as MainFilteredInformer.
+func (c *Context) MainFilteredClusterInformer(config *Config, gvk schema.GroupVersionKind, f func(kubernetes.Interface, time.Duration, cache.Indexers, internalinterfaces.TweakListOptionsFunc) cache.SharedIndexInformer) (cache.SharedIndexInformer, error) {
+	inf := c.Informers[gvk]
+	if inf == nil {
+		selector := c.informerSelector(config, gvk)
+		if err := selector.Validate(); err != nil {
+			return nil, err
+		}
+		inf = f(config.MainClient, config.ResyncPeriod, cache.Indexers{}, selector.ApplyTo)
+		err := c.registerFilteredInformer(gvk, inf, selector)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return inf, nil
+}
+
+func (c *Context) registerFilteredInformer(gvk schema.GroupVersionKind, inf cache.SharedIndexInformer, selector Selector) error {
+	if err := c.RegisterInformer(gvk, inf); err != nil {
+		return err
+	}
+	if selector.IsEmpty() {
+		return nil
+	}
+	if c.InformerSelectors == nil {
+		c.InformerSelectors = make(map[schema.GroupVersionKind]Selector)
+	}
+	c.InformerSelectors[gvk] = selector
+	return nil
+}
+
+// MainMetadataInformer constructs and registers an informer that only caches metadata of objects of gvk
+// in the configured namespaces. Objects are of type *meta_v1.PartialObjectMetadata. resource is the plural
+// resource name of gvk e.g. "configmaps". Objects are restricted the same way as by MainFilteredInformer
+// and transformed using Config.InformerTransform.
+func (c *Context) MainMetadataInformer(config *Config, gvk schema.GroupVersionKind, resource string) (cache.SharedIndexInformer, error) {
+	if config.MetadataClient == nil {
+		return nil, errors.Errorf("metadata informer for GVK %s requires a metadata client", gvk)
+	}
+	client := config.MetadataClient.Resource(gvk.GroupVersion().WithResource(resource))
+	return c.mainListWatchInformer(config, gvk, &meta_v1.PartialObjectMetadata{}, true, func(namespace string, tweak internalinterfaces.TweakListOptionsFunc) cache.ListerWatcher {
+		return newMetadataListWatch(client.Namespace(namespace), tweak)
+	})
+}
+
+// MainMetadataClusterInformer is like MainMetadataInformer but for cluster scoped objects.
+func (c *Context) MainMetadataClusterInformer(config *Config, gvk schema.GroupVersionKind, resource string) (cache.SharedIndexInformer, error) {
+	if config.MetadataClient == nil {
+		return nil, errors.Errorf("metadata informer for GVK %s requires a metadata client", gvk)
+	}
+	client := config.MetadataClient.Resource(gvk.GroupVersion().WithResource(resource))
+	return c.mainListWatchInformer(config, gvk, &meta_v1.PartialObjectMetadata{}, false, func(_ string, tweak internalinterfaces.TweakListOptionsFunc) cache.ListerWatcher {
+		return newMetadataListWatch(client, tweak)
+	})
+}
+
+// MainTransformedInformer constructs and registers an informer for objects of gvk in the configured namespaces.
+// Objects are transformed using Config.InformerTransform before they are stored in the informer's cache.
+// objType is an empty object of the type e.g. &core_v1.Secret{}. newListWatch constructs a ListerWatcher
+// for the namespace, tweak must be applied to list options of List and Watch calls.
+// Objects are restricted the same way as by MainFilteredInformer.
+func (c *Context) MainTransformedInformer(config *Config, gvk schema.GroupVersionKind, objType runtime.Object, newListWatch func(kubernetes.Interface, string, internalinterfaces.TweakListOptionsFunc) cache.ListerWatcher) (cache.SharedIndexInformer, error) {
+	return c.mainListWatchInformer(config, gvk, objType, true, func(namespace string, tweak internalinterfaces.TweakListOptionsFunc) cache.ListerWatcher {
+		return newListWatch(config.MainClient, namespace, tweak)
+	})
+}
+
+// MainDynamicInformer constructs and registers an informer for objects of gvk as *unstructured.Unstructured.
+// It can be used for any GVK, including custom resources without generated clients. Resource and scope of gvk
+// are discovered using Config.RESTMapper. Objects are restricted the same way as by MainFilteredInformer
+// and transformed using Config.InformerTransform.
+func (c *Context) MainDynamicInformer(config *Config, gvk schema.GroupVersionKind) (cache.SharedIndexInformer, error) {
+	if inf := c.Informers[gvk]; inf != nil {
+		return inf, nil
+	}
+	if config.DynamicClient == nil || config.RESTMapper == nil {
+		return nil, errors.Errorf("dynamic informer for GVK %s requires a dynamic client and a REST mapper", gvk)
+	}
+	mapping, err := config.RESTMapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to find resource for GVK %s", gvk)
+	}
+	client := config.DynamicClient.Resource(mapping.Resource)
+	namespaced := mapping.Scope.Name() == meta.RESTScopeNameNamespace
+	return c.mainListWatchInformer(config, gvk, &unstructured.Unstructured{}, namespaced, func(namespace string, tweak internalinterfaces.TweakListOptionsFunc) cache.ListerWatcher {
+		var resourceClient dynamic.ResourceInterface = client
+		if namespaced {
+			resourceClient = client.Namespace(namespace)
+		}
+		return &cache.ListWatch{
+			ListFunc: func(options meta_v1.ListOptions) (runtime.Object, error) {
+				tweak(&options)
+				return resourceClient.List(options)
+			},
+			WatchFunc: func(options meta_v1.ListOptions) (watch.Interface, error) {
+				tweak(&options)
+				return resourceClient.Watch(options)
+			},
+		}
+	})
+}
+
+func (c *Context) mainListWatchInformer(config *Config, gvk schema.GroupVersionKind, objType runtime.Object, namespaced bool, newListWatch func(string, internalinterfaces.TweakListOptionsFunc) cache.ListerWatcher) (cache.SharedIndexInformer, error) {
+	inf := c.Informers[gvk]
+	if inf != nil {
+		return inf, nil
+	}
+	selector := c.informerSelector(config, gvk)
+	if err := selector.Validate(); err != nil {
+		return nil, err
+	}
+	inf = newListWatchInformer(config, config.MainClient, selector, objType, namespaced, newListWatch)
+	if err := c.registerFilteredInformer(gvk, inf, selector); err != nil {
+		return nil, err
+	}
+	return inf, nil
+}
+
+// newListWatchInformer constructs an informer for objects in the configured namespaces using ListerWatchers
+// constructed by newListWatch. Objects are restricted using the selector and transformed using
+// Config.InformerTransform. client is used to discover namespaces.
+func newListWatchInformer(config *Config, client kubernetes.Interface, selector Selector, objType runtime.Object, namespaced bool, newListWatch func(string, internalinterfaces.TweakListOptionsFunc) cache.ListerWatcher) cache.SharedIndexInformer {
+	newInformer := func(namespace string) cache.SharedIndexInformer {
+		lw := NewTransformingListWatch(newListWatch(namespace, selector.ApplyTo), config.InformerTransform)
+		return cache.NewSharedIndexInformer(lw, objType, config.ResyncPeriod, cache.Indexers{})
+	}
+	switch {
+	case !namespaced:
+		return newInformer(meta_v1.NamespaceNone)
+	case len(config.Namespaces) > 0 || config.NamespaceSelector != "":
+		return NewMultiNamespaceInformer(config.Logger, client, config.Namespaces, config.NamespaceSelector, config.ResyncPeriod, newInformer)
+	default:
+		return newInformer(config.Namespace)
+	}
+}
+
+func newMetadataListWatch(client metadata.ResourceInterface, tweak internalinterfaces.TweakListOptionsFunc) cache.ListerWatcher {
+	return &cache.ListWatch{
+		ListFunc: func(options meta_v1.ListOptions) (runtime.Object, error) {
+			tweak(&options)
+			return client.List(options)
+		},
+		WatchFunc: func(options meta_v1.ListOptions) (watch.Interface, error) {
+			tweak(&options)
+			return client.Watch(options)
+		},
+	}
+}
+
+// checkUnfilteredInformer returns an error if objects of gvk should be restricted by a selector because
+// an informer that cannot apply it would watch and process objects that should be ignored.
+func (c *Context) checkUnfilteredInformer(config *Config, gvk schema.GroupVersionKind, filteredConstructor string) error {
+	if selector := c.informerSelector(config, gvk); !selector.IsEmpty() {
+		return errors.Errorf("informer for GVK %s cannot apply selector %+v, %s must be used", gvk, selector, filteredConstructor)
+	}
+	return nil
+}
+
+// informerSelector returns the selector for the main informer for gvk.
+func (c *Context) informerSelector(config *Config, gvk schema.GroupVersionKind) Selector {
+	selector := config.InformerSelector
+	if gvk == c.Descriptor.Gvk {
+		selector = selector.And(c.Descriptor.Selector)
+	}
+	return selector
+}